@@ -0,0 +1,329 @@
+package docindex
+
+import (
+	"bytes"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"sync"
+)
+
+// CodeIndex is a trigram index over the Go source files of the indexed
+// packages. It lives in memory, apart from the documentation index, and it is
+// loaded from a SourceStore.
+type CodeIndex struct {
+	mutex    sync.RWMutex
+	files    []*codeFile
+	packages map[string][]int
+	trigrams map[string][]int
+	// dead is the number of replaced files whose ids are still in files and
+	// in the postings.
+	dead int
+}
+
+type codeFile struct {
+	importPath string
+	name       string
+	data       []byte
+}
+
+// CodeMatch is a line matching a code search.
+type CodeMatch struct {
+	ImportPath string
+	File       string
+	Line       int
+	SourceLink string
+	Context    []CodeLine
+}
+
+// CodeLine is a line of sourcecode shown around a match.
+type CodeLine struct {
+	Number int
+	Text   string
+	Match  bool
+}
+
+const (
+	codeContextLines   = 2
+	codeMatchesPerFile = 5
+)
+
+// minCompactDead is the number of replaced files from which the index is
+// compacted, once they are also half of its files.
+const minCompactDead = 256
+
+// NewCodeIndex returns an empty code index.
+func NewCodeIndex() *CodeIndex {
+	return &CodeIndex{
+		packages: map[string][]int{},
+		trigrams: map[string][]int{},
+	}
+}
+
+// Load adds all the packages of a source store to the index.
+func (ci *CodeIndex) Load(store *SourceStore) error {
+	pkgs, err := store.Packages()
+	if err != nil {
+		return err
+	}
+	for _, importPath := range pkgs {
+		err := ci.AddPackage(store, importPath)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddPackage (re)indexes the Go source files of a stored package.
+func (ci *CodeIndex) AddPackage(store *SourceStore, importPath string) error {
+	names, err := store.Files(importPath)
+	if err != nil {
+		return err
+	}
+	files := []*codeFile{}
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		data, err := store.File(importPath, name)
+		if err != nil {
+			return err
+		}
+		files = append(files, &codeFile{importPath: importPath, name: name, data: data})
+	}
+
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+	// Replaced files are only unlinked, their ids are skipped when searching
+	// until the index is compacted
+	for _, id := range ci.packages[importPath] {
+		ci.files[id] = nil
+	}
+	ci.dead += len(ci.packages[importPath])
+	ids := make([]int, len(files))
+	for i, file := range files {
+		id := len(ci.files)
+		ci.files = append(ci.files, file)
+		for _, t := range fileTrigrams(file.data) {
+			ci.trigrams[t] = append(ci.trigrams[t], id)
+		}
+		ids[i] = id
+	}
+	ci.packages[importPath] = ids
+	if ci.dead >= minCompactDead && 2*ci.dead >= len(ci.files) {
+		ci.compact()
+	}
+	return nil
+}
+
+// compact drops the replaced files, renumbering the rest and rebuilding the
+// postings. Ids are given in order, so postings stay sorted.
+func (ci *CodeIndex) compact() {
+	files := make([]*codeFile, 0, len(ci.files)-ci.dead)
+	ids := make([]int, len(ci.files))
+	for id, file := range ci.files {
+		if file == nil {
+			continue
+		}
+		ids[id] = len(files)
+		files = append(files, file)
+	}
+	for importPath, pkgIDs := range ci.packages {
+		for i, id := range pkgIDs {
+			pkgIDs[i] = ids[id]
+		}
+		ci.packages[importPath] = pkgIDs
+	}
+	ci.files = files
+	ci.trigrams = map[string][]int{}
+	for id, file := range files {
+		for _, t := range fileTrigrams(file.data) {
+			ci.trigrams[t] = append(ci.trigrams[t], id)
+		}
+	}
+	ci.dead = 0
+}
+
+// Search looks for lines matching query, either literally or as a regular
+// expression, returning at most max matches.
+func (ci *CodeIndex) Search(query string, isRegexp bool, max int) ([]*CodeMatch, error) {
+	expr := query
+	if !isRegexp {
+		expr = regexp.QuoteMeta(query)
+	}
+	re, err := regexp.Compile("(?m)" + expr)
+	if err != nil {
+		return nil, err
+	}
+	required, err := requiredLiterals(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	ci.mutex.RLock()
+	defer ci.mutex.RUnlock()
+	matches := []*CodeMatch{}
+	for _, id := range ci.candidates(required) {
+		file := ci.files[id]
+		if file == nil {
+			continue
+		}
+		matches = append(matches, searchFile(file, re, max-len(matches))...)
+		if len(matches) >= max {
+			break
+		}
+	}
+	return matches, nil
+}
+
+// candidates returns the ids of the files containing all the trigrams of the
+// required literals, or every file when there are no trigrams to look for.
+func (ci *CodeIndex) candidates(literals []string) []int {
+	var ids []int
+	filtered := false
+	for _, lit := range literals {
+		for _, t := range stringTrigrams(lit) {
+			posting := ci.trigrams[t]
+			if !filtered {
+				ids = posting
+				filtered = true
+			} else {
+				ids = intersectPostings(ids, posting)
+			}
+			if len(ids) <= 0 {
+				return nil
+			}
+		}
+	}
+	if filtered {
+		return ids
+	}
+	ids = make([]int, len(ci.files))
+	for i := range ci.files {
+		ids[i] = i
+	}
+	return ids
+}
+
+func searchFile(file *codeFile, re *regexp.Regexp, max int) []*CodeMatch {
+	if max <= 0 {
+		return nil
+	}
+	limit := codeMatchesPerFile
+	if max < limit {
+		limit = max
+	}
+	locs := re.FindAllIndex(file.data, -1)
+	if len(locs) <= 0 {
+		return nil
+	}
+	lines := strings.Split(string(file.data), "\n")
+	matches := []*CodeMatch{}
+	lastLine := 0
+	for _, loc := range locs {
+		line := bytes.Count(file.data[:loc[0]], []byte("\n")) + 1
+		if line == lastLine {
+			continue
+		}
+		lastLine = line
+		matches = append(matches, &CodeMatch{
+			ImportPath: file.importPath,
+			File:       file.name,
+			Line:       line,
+			SourceLink: SourceViewLink(file.importPath, file.name, line),
+			Context:    contextLines(lines, line),
+		})
+		if len(matches) >= limit {
+			break
+		}
+	}
+	return matches
+}
+
+func contextLines(lines []string, line int) []CodeLine {
+	from := line - codeContextLines
+	if from < 1 {
+		from = 1
+	}
+	to := line + codeContextLines
+	if to > len(lines) {
+		to = len(lines)
+	}
+	ctx := []CodeLine{}
+	for n := from; n <= to; n++ {
+		ctx = append(ctx, CodeLine{Number: n, Text: lines[n-1], Match: n == line})
+	}
+	return ctx
+}
+
+// requiredLiterals returns the literal strings any match of a regular
+// expression has to contain. Only the top level concatenation is inspected,
+// which is enough to prune most of the files on usual queries.
+func requiredLiterals(expr string) ([]string, error) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	re = re.Simplify()
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+	literals := []string{}
+	for _, sub := range subs {
+		if sub.Op == syntax.OpCapture && len(sub.Sub) == 1 {
+			sub = sub.Sub[0]
+		}
+		if sub.Op == syntax.OpLiteral && sub.Flags&syntax.FoldCase == 0 {
+			literals = append(literals, string(sub.Rune))
+		}
+	}
+	return literals, nil
+}
+
+func fileTrigrams(data []byte) []string {
+	seen := map[string]bool{}
+	ts := []string{}
+	for i := 0; i+3 <= len(data); i++ {
+		t := string(data[i : i+3])
+		if !seen[t] {
+			seen[t] = true
+			ts = append(ts, t)
+		}
+	}
+	return ts
+}
+
+func stringTrigrams(s string) []string {
+	ts := []string{}
+	for i := 0; i+3 <= len(s); i++ {
+		ts = append(ts, s[i:i+3])
+	}
+	return ts
+}
+
+// intersectPostings intersects two sorted posting lists.
+func intersectPostings(a, b []int) []int {
+	r := []int{}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			r = append(r, a[i])
+			i++
+			j++
+		}
+	}
+	return r
+}
+
+// Packages returns the number of packages in the index.
+func (ci *CodeIndex) Packages() int {
+	ci.mutex.RLock()
+	defer ci.mutex.RUnlock()
+	return len(ci.packages)
+}
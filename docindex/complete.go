@@ -0,0 +1,137 @@
+package docindex
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+)
+
+// Completion is an indexed symbol whose name, or qualified name, starts like
+// what is being typed.
+type Completion struct {
+	Name     string  `json:"name"`
+	Receiver string  `json:"recv,omitempty"`
+	Kind     DocKind `json:"kind"`
+	Import   string  `json:"import"`
+	Link     string  `json:"link"`
+
+	// exact is set when one of the names of the symbol is the prefix, and
+	// boost is how much it is promoted in searches.
+	exact bool
+	boost float64
+	id    string
+}
+
+// MaxCompletions is the largest number of completions returned at once.
+const MaxCompletions = 50
+
+// completionWindow is how many prefix matches are ranked to pick the
+// completions. They are ranked like search results are promoted, so the
+// window is larger than the completions returned.
+const completionWindow = 200
+
+// completionNames returns the names a symbol is completed from, which are
+// its name and its qualified names, like "http.server".
+func completionNames(names ...string) []string {
+	lower := make([]string, len(names))
+	for i, name := range names {
+		lower[i] = strings.ToLower(name)
+	}
+	return lower
+}
+
+// Complete returns up to n symbols whose name starts with prefix, ignoring
+// case. Prefixes with dots complete qualified names, so "http.Ser" finds
+// http.Server and "Buffer.Wr" the Write methods of the Buffer types.
+// Exact names come first, then the symbols promoted the most in searches,
+// by kind and inbound doc links, and then the shortest names.
+func Complete(index bleve.Index, prefix string, n int) ([]*Completion, error) {
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	if len(prefix) <= 0 || n <= 0 {
+		return []*Completion{}, nil
+	}
+	if n > MaxCompletions {
+		n = MaxCompletions
+	}
+	// Exact names are searched apart, so they are never left out of the
+	// window by other matches
+	exact, err := searchCompletions(index, bleve.NewTermQuery(prefix).SetField("completions"), n, prefix)
+	if err != nil {
+		return nil, err
+	}
+	query := bleve.NewPrefixQuery(prefix).SetField("completions")
+	matches, err := searchCompletions(index, query, completionWindow, prefix)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	completions := []*Completion{}
+	for _, c := range append(exact, matches...) {
+		if !seen[c.id] {
+			seen[c.id] = true
+			completions = append(completions, c)
+		}
+	}
+	sort.Sort(byCompletionRank(completions))
+	if len(completions) > n {
+		completions = completions[:n]
+	}
+	return completions, nil
+}
+
+// searchCompletions returns the symbols matching a completion query, up to
+// size of them.
+func searchCompletions(index bleve.Index, query bleve.Query, size int, prefix string) ([]*Completion, error) {
+	search := bleve.NewSearchRequestOptions(query, size, 0, false)
+	search.Fields = []string{"name", "kind", "import", "recv", "completions", "inbound"}
+	sr, err := index.Search(search)
+	if err != nil {
+		return nil, err
+	}
+	completions := []*Completion{}
+	for _, hit := range sr.Hits {
+		name, _ := hit.Fields["name"].(string)
+		kind, _ := hit.Fields["kind"].(string)
+		importPath, _ := hit.Fields["import"].(string)
+		receiver, _ := hit.Fields["recv"].(string)
+		tier, _ := hit.Fields["inbound"].(string)
+		c := &Completion{
+			Name:     name,
+			Receiver: receiver,
+			Kind:     DocKind(kind),
+			Import:   importPath,
+			Link:     PageLink(DocKind(kind), importPath, name, receiver),
+			boost:    resultBoost(DocKind(kind), tier),
+			id:       hit.ID,
+		}
+		for _, completion := range stringsField(hit.Fields, "completions") {
+			if completion == prefix {
+				c.exact = true
+			}
+		}
+		completions = append(completions, c)
+	}
+	return completions, nil
+}
+
+type byCompletionRank []*Completion
+
+func (cs byCompletionRank) Len() int      { return len(cs) }
+func (cs byCompletionRank) Swap(i, j int) { cs[i], cs[j] = cs[j], cs[i] }
+func (cs byCompletionRank) Less(i, j int) bool {
+	a, b := cs[i], cs[j]
+	if a.exact != b.exact {
+		return a.exact
+	}
+	if a.boost != b.boost {
+		return a.boost > b.boost
+	}
+	if len(a.Name) != len(b.Name) {
+		return len(a.Name) < len(b.Name)
+	}
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	return a.Import < b.Import
+}
@@ -1,8 +1,11 @@
 package docindex
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"time"
 
 	"go/doc"
 
@@ -10,6 +13,37 @@ import (
 	"github.com/golang/gddo/gosrc"
 )
 
+// PackageStatus is the outcome of indexing a package.
+type PackageStatus string
+
+const (
+	// StatusIndexed is the status of a package indexed without problems.
+	StatusIndexed PackageStatus = "indexed"
+	// StatusPartial is the status of a package indexed in spite of some
+	// problems, like files with syntax errors.
+	StatusPartial PackageStatus = "partial"
+	// StatusNoGoFiles is the status of a package without Go files buildable
+	// on any of the indexed platforms.
+	StatusNoGoFiles PackageStatus = "no-go-files"
+)
+
+// IndexError is returned when a package can not be indexed at all.
+type IndexError struct {
+	ImportPath string
+	Status     PackageStatus
+	Err        error
+	// Warnings are the problems found before giving up.
+	Warnings []string
+}
+
+func (e *IndexError) Error() string {
+	return fmt.Sprintf("%s: %s (%s)", e.ImportPath, e.Err.Error(), e.Status)
+}
+
+// ErrNotModified is returned when a package has not changed since it was
+// indexed.
+var ErrNotModified = errors.New("Package not modified")
+
 // SetLocalDevMode sets the package to local development mode.
 func SetLocalDevMode(path string) {
 	gosrc.SetLocalDevMode(path)
@@ -17,43 +51,220 @@ func SetLocalDevMode(path string) {
 
 // IndexPackage ...
 // TODO(alvivi): doc this
-func IndexPackage(client *http.Client, index bleve.Index, pkgPath string) error {
-	pkg, err := fetchPackage(client, pkgPath)
+func IndexPackage(client *http.Client, index bleve.Index, sources *SourceStore, meta *MetaStore, pkgPath string) error {
+	rec := &PackageRecord{ImportPath: pkgPath}
+	if meta != nil {
+		// A failed attempt keeps what was known about the indexed version
+		prev, err := meta.Get(pkgPath)
+		if err == nil {
+			rec = prev
+		}
+	}
+	// Packages are fetched again only if they changed, as long as they are
+	// still in the index. After a failure everything is fetched again, since
+	// the failed attempt may have left the index half updated.
+	etag := ""
+	if rec.Failures == 0 && isIndexed(index, pkgPath) {
+		etag = rec.Etag
+	}
+	rec.CheckedAt = time.Now()
+	rec.Version = packageVersion(pkgPath)
+	stale, err := indexPackage(client, index, sources, pkgPath, etag, rec)
+	if err == nil {
+		rec.FetchedAt = rec.CheckedAt
+		rec.Failures = 0
+	} else if err == ErrNotModified {
+		rec.Failures = 0
+		rec.Error = ""
+	} else {
+		rec.Failures++
+		rec.Error = err.Error()
+		// The last good version is still served, so it keeps its status
+		if !rec.isIndexed() || !isIndexed(index, pkgPath) {
+			rec.Status = StatusFailed
+			rec.Warnings = nil
+			if ierr, ok := err.(*IndexError); ok {
+				rec.Status = ierr.Status
+				rec.Warnings = ierr.Warnings
+			}
+		}
+	}
+	if meta != nil {
+		merr := meta.Put(rec)
+		if merr != nil {
+			log.Printf("Error recording package %s: %s.\n", pkgPath, merr.Error())
+		}
+	}
+	if sources != nil && meta != nil {
+		reindexStale(index, sources, meta, stale)
+	}
+	return err
+}
+
+func indexPackage(client *http.Client, index bleve.Index, sources *SourceStore, pkgPath, etag string, rec *PackageRecord) ([]string, error) {
+	fetched, err := fetchPackage(client, pkgPath, etag)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	pkgDesc := NewPackage(doc.New(pkg, pkgPath, 0))
-	// Functions
-	for _, fnDesc := range pkgDesc.Funcs {
-		fnName := fmt.Sprintf("%s.%s", pkgDesc.ImportPath, fnDesc.Name)
-		err := index.Index(fnName, fnDesc)
+	if sources != nil {
+		err = sources.PutDirectory(fetched.dir)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
-	// Constants
-	for _, constDesc := range pkgDesc.Consts {
-		constName := fmt.Sprintf("%s.%s", pkgDesc.ImportPath, constDesc.Name)
-		err := index.Index(constName, constDesc)
+	rec.Etag = fetched.dir.Etag
+	rec.VCS = fetched.dir.VCS
+	rec.BrowseURL = fetched.dir.BrowseURL
+	rec.License = detectLicense(fetched.dir)
+	return indexFetched(index, fetched, rec, rec.CheckedAt)
+}
+
+// indexFetched indexes the documentation of a read package, fetched at the
+// given time. It returns the other packages whose promotion by inbound doc
+// links changed with it, which have to be indexed again.
+func indexFetched(index bleve.Index, fetched *fetchedPackage, rec *PackageRecord, fetchedAt time.Time) ([]string, error) {
+	pkgPath := fetched.dir.ImportPath
+	var pkgDesc *Package
+	for _, envPkg := range fetched.envs {
+		envDesc := NewPackage(doc.New(envPkg.pkg, pkgPath, 0), envPkg.src)
+		if pkgDesc == nil {
+			pkgDesc = envDesc
+			pkgDesc.setPlatforms(envPkg.platforms)
+		} else {
+			pkgDesc.merge(envDesc, envPkg.platforms)
+		}
+	}
+	pkgDesc.setIndexed(fetchedAt)
+	pkgDesc.Status = StatusIndexed
+	if len(fetched.warnings) > 0 {
+		pkgDesc.Status = StatusPartial
+		pkgDesc.Warnings = fetched.warnings
+		for _, w := range fetched.warnings {
+			log.Printf("Warning indexing package %s: %s.\n", pkgPath, w)
+		}
+	}
+	rec.Status = pkgDesc.Status
+	rec.Error = ""
+	rec.Warnings = pkgDesc.Warnings
+	// Documents of symbols removed since the package was last indexed are
+	// deleted once the new ones are in, so the package never goes missing
+	previous, previousTargets, err := packageDocs(index, pkgPath)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := inboundCounts(index, pkgPath)
+	if err != nil {
+		return nil, err
+	}
+	pkgDesc.setInbound(counts)
+	docs := pkgDesc.docs()
+	current := map[string]bool{}
+	for _, d := range docs {
+		current[d.id] = true
+		err := index.Index(d.id, d.data)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
-	// Variables
-	for _, varDesc := range pkgDesc.Vars {
-		varName := fmt.Sprintf("%s.%s", pkgDesc.ImportPath, varDesc.Name)
-		err := index.Index(varName, varDesc)
+	for _, id := range previous {
+		if current[id] {
+			continue
+		}
+		err := index.Delete(id)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
-	// Types
-	for _, typeDesc := range pkgDesc.Types {
-		typeName := fmt.Sprintf("%s.%s", pkgDesc.ImportPath, typeDesc.Name)
-		err := index.Index(typeName, typeDesc)
+	// Doc links within the package changed with it, so the documents whose
+	// promotion tier changed with them are indexed again
+	newCounts, err := inboundCounts(index, pkgPath)
+	if err != nil {
+		return nil, err
+	}
+	pkgDesc.setInbound(newCounts)
+	for _, d := range docs {
+		if inboundTier(counts[d.id]) == inboundTier(newCounts[d.id]) {
+			continue
+		}
+		err := index.Index(d.id, d.data)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
-	return index.Index(pkgDesc.ImportPath, pkgDesc)
+	return staleInbound(index, pkgPath, append(previousTargets, pkgDesc.outboundTargets()...))
+}
+
+// indexedDoc is a document of a package as it is indexed.
+type indexedDoc struct {
+	id   string
+	data interface{}
+}
+
+// docs returns the documents of a package, itself included.
+func (pkg *Package) docs() []indexedDoc {
+	docs := []indexedDoc{}
+	// Functions
+	for _, fnDesc := range pkg.Funcs {
+		docs = append(docs, indexedDoc{fnDesc.ID(), fnDesc})
+	}
+	// Constants
+	for _, constDesc := range pkg.Consts {
+		docs = append(docs, indexedDoc{constDesc.ID(), constDesc})
+	}
+	// Variables
+	for _, varDesc := range pkg.Vars {
+		docs = append(docs, indexedDoc{varDesc.ID(), varDesc})
+	}
+	// Types
+	for _, typeDesc := range pkg.Types {
+		docs = append(docs, indexedDoc{typeDesc.ID(), typeDesc})
+	}
+	return append(docs, indexedDoc{pkg.ImportPath, pkg})
+}
+
+// packageDocs returns the identifiers of the indexed documents of a package,
+// itself included, and the targets of their doc links.
+func packageDocs(index bleve.Index, importPath string) ([]string, []string, error) {
+	query := bleve.NewTermQuery(importPath).SetField("import")
+	sr, err := index.Search(bleve.NewSearchRequestOptions(query, 0, 0, false))
+	if err != nil || sr.Total <= 0 {
+		return nil, nil, err
+	}
+	search := bleve.NewSearchRequestOptions(query, int(sr.Total), 0, false)
+	search.Fields = []string{"refs.target"}
+	sr, err = index.Search(search)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids := make([]string, len(sr.Hits))
+	targets := []string{}
+	for i, hit := range sr.Hits {
+		ids[i] = hit.ID
+		targets = append(targets, stringsField(hit.Fields, "refs.target")...)
+	}
+	return ids, targets, nil
+}
+
+// setIndexed sets when a package, and its entries, were fetched.
+func (pkg *Package) setIndexed(at time.Time) {
+	pkg.Indexed = at
+	for _, fn := range pkg.Funcs {
+		fn.Indexed = at
+	}
+	for _, v := range pkg.Consts {
+		v.Indexed = at
+	}
+	for _, v := range pkg.Vars {
+		v.Indexed = at
+	}
+	for _, t := range pkg.Types {
+		t.Indexed = at
+	}
+}
+
+// isIndexed reports whether the documentation of a package is in the index.
+func isIndexed(index bleve.Index, importPath string) bool {
+	search := bleve.NewSearchRequestOptions(bleve.NewDocIDQuery([]string{importPath}), 0, 0, false)
+	sr, err := index.Search(search)
+	return err == nil && sr.Total > 0
 }
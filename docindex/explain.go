@@ -0,0 +1,48 @@
+package docindex
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/blevesearch/bleve/search"
+)
+
+// Explanation is how the score of a search result was computed: a value,
+// the reason for it and the values it was computed from.
+type Explanation struct {
+	Value    float64        `json:"value"`
+	Message  string         `json:"message"`
+	Children []*Explanation `json:"children,omitempty"`
+}
+
+// explainScore explains the score of a search result: the score of its
+// match, as bleve explains it, multiplied by the promotions applied after the
+// search.
+func explainScore(expl *search.Explanation, kind DocKind, tier string) *Explanation {
+	children := []*Explanation{
+		convertExplanation(expl),
+		{
+			Value:   kindMappings[kind].boost,
+			Message: fmt.Sprintf("factor of %s results", kindMappings[kind].docType),
+		},
+	}
+	if t, err := strconv.Atoi(tier); err == nil && t > 0 {
+		children = append(children, &Explanation{
+			Value:   inboundFactor(tier),
+			Message: fmt.Sprintf("factor of results with %d or more inbound doc links", uint64(1)<<uint(t-1)),
+		})
+	}
+	return &Explanation{
+		Value:    expl.Value * resultBoost(kind, tier),
+		Message:  "product of:",
+		Children: children,
+	}
+}
+
+func convertExplanation(expl *search.Explanation) *Explanation {
+	e := &Explanation{Value: expl.Value, Message: expl.Message}
+	for _, child := range expl.Children {
+		e.Children = append(e.Children, convertExplanation(child))
+	}
+	return e
+}
@@ -0,0 +1,88 @@
+package docindex
+
+import (
+	"strings"
+
+	"github.com/blevesearch/bleve"
+)
+
+// facetFields are the fields search results are counted by, with the
+// filter narrowing a query to each of their terms.
+var facetFields = []struct {
+	field  string
+	name   string
+	filter string
+	size   int
+}{
+	{"kind", "Kind", "kind", 8},
+	{"import", "Package", "in", 10},
+	{"host", "Host", "host", 5},
+}
+
+// kindLabels are the names of the kinds as shown in facets.
+var kindLabels = map[DocKind]string{
+	PackageKind: "Packages",
+	CommandKind: "Commands",
+	TypeKind:    "Types",
+	FuncKind:    "Functions",
+	MethodKind:  "Methods",
+	ConstKind:   "Constants",
+	VarKind:     "Variables",
+}
+
+// Facet is the count of the results of a search by the values of a field.
+type Facet struct {
+	Name  string       `json:"name"`
+	Field string       `json:"field"`
+	Terms []*FacetTerm `json:"terms"`
+}
+
+// FacetTerm is a value of a facet field.
+type FacetTerm struct {
+	Term  string `json:"term"`
+	Label string `json:"label"`
+	Count int    `json:"count"`
+	// Query is the query narrowed to the results with this value, and Link
+	// the link to its results.
+	Query string `json:"query"`
+	Link  string `json:"link"`
+	// Active is set when the query is already narrowed to this value.
+	Active bool `json:"active"`
+}
+
+func addFacets(search *bleve.SearchRequest) {
+	for _, ff := range facetFields {
+		search.AddFacet(ff.field, bleve.NewFacetRequest(ff.field, ff.size))
+	}
+}
+
+// newFacets builds the facets of the results of a query, where pq is the
+// query with the filters of the search options.
+func newFacets(queryString string, pq parsedQuery, opts SearchOptions, sr *bleve.SearchResult) []*Facet {
+	facets := []*Facet{}
+	for _, ff := range facetFields {
+		fr, ok := sr.Facets[ff.field]
+		if !ok || len(fr.Terms) <= 0 {
+			continue
+		}
+		facet := &Facet{Name: ff.name, Field: ff.field}
+		for _, tf := range fr.Terms {
+			term := &FacetTerm{Term: tf.Term, Label: tf.Term, Count: tf.Count}
+			value := tf.Term
+			if ff.field == "kind" {
+				kind := DocKind(tf.Term)
+				term.Label = kindLabels[kind]
+				value = kindMappings[kind].docType
+			}
+			term.Active = pq.hasFilter(ff.filter, value)
+			term.Query = queryString
+			if !term.Active {
+				term.Query = strings.TrimSpace(queryString + " " + ff.filter + ":" + value)
+			}
+			term.Link = opts.Link(term.Query)
+			facet.Terms = append(facet.Terms, term)
+		}
+		facets = append(facets, facet)
+	}
+	return facets
+}
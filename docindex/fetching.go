@@ -2,70 +2,196 @@ package docindex
 
 import (
 	"errors"
+	"fmt"
 	"go/ast"
 	"go/build"
 	"go/parser"
+	"go/scanner"
 	"go/token"
 	"net/http"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/golang/gddo/gosrc"
 )
 
-func fetchPackage(client *http.Client, path string) (*ast.Package, error) {
-	dir, err := gosrc.Get(client, path, "")
+// fetchedPackage is a package fetched and parsed from its repository, once
+// per distinct set of files selected by the build environments.
+type fetchedPackage struct {
+	dir  *gosrc.Directory
+	envs []*envPackage
+	// warnings are the problems found while reading the package which did
+	// not prevent indexing it.
+	warnings []string
+}
+
+// envPackage is a package as seen by one or more build environments.
+type envPackage struct {
+	platforms []string
+	pkg       *ast.Package
+	src       *Source
+}
+
+// fetchPackage fetches a package, unless its etag is still the given one,
+// in which case it returns ErrNotModified.
+func fetchPackage(client *http.Client, path, etag string) (*fetchedPackage, error) {
+	dir, err := gosrc.Get(client, path, etag)
+	if _, ok := err.(gosrc.NotModifiedError); ok {
+		return nil, ErrNotModified
+	}
 	if err != nil {
 		return nil, err
 	}
-	// Fisrt, we have to find a proper build context for the package
+	return readPackage(dir)
+}
+
+// readPackage parses the files of a fetched directory.
+func readPackage(dir *gosrc.Directory) (*fetchedPackage, error) {
+	filesData := map[string][]byte{}
+	for _, file := range dir.Files {
+		if strings.HasSuffix(file.Name, ".go") {
+			gosrc.OverwriteLineComments(file.Data)
+		}
+		filesData[file.Name] = file.Data
+		// TODO(alvivi): else { addReferences(references, file.Data) }
+	}
+	// Each build environment may select a different set of files, so the
+	// package is parsed once per distinct set.
 	bctx := build.Context{
 		CgoEnabled:  true,
 		ReleaseTags: build.Default.ReleaseTags,
 		BuildTags:   build.Default.BuildTags,
 		Compiler:    "gc",
 	}
-	var bpkg *build.Package
+	fetched := &fetchedPackage{dir: dir}
+	envsByFiles := map[string]*envPackage{}
 	for _, env := range buildEnvs {
 		bctx.GOOS = env.GOOS
 		bctx.GOARCH = env.GOARCH
-		bpkg, err = dir.Import(&bctx, build.ImportComment)
-		if _, ok := err.(*build.NoGoError); !ok {
-			break
+		bpkg, err := dir.Import(&bctx, build.ImportComment)
+		if _, ok := err.(*build.NoGoError); ok {
+			continue
+		}
+		if err != nil {
+			// Import returns whatever it found along with errors like mixed
+			// package names, and that is usually enough for documentation.
+			fetched.warn(fmt.Sprintf("%s: %s", env, err.Error()))
 		}
+		if bpkg == nil {
+			continue
+		}
+		fileNames := append(bpkg.GoFiles, bpkg.CgoFiles...)
+		sort.Strings(fileNames)
+		key := strings.Join(fileNames, "\n")
+		if envPkg, ok := envsByFiles[key]; ok {
+			envPkg.platforms = append(envPkg.platforms, env.String())
+			continue
+		}
+		// Parse all package's sourcecode. A file with syntax errors still
+		// yields a partial AST, so it is kept and reported as a warning.
+		fileSet := token.NewFileSet()
+		pkgFiles := map[string]*ast.File{}
+		for _, fname := range fileNames {
+			pfile, err := parser.ParseFile(fileSet, fname, filesData[fname],
+				parser.ParseComments|parser.AllErrors)
+			if err != nil {
+				fetched.warn(parseWarning(fname, err))
+			}
+			if pfile != nil {
+				pkgFiles[fname] = pfile
+			}
+		}
+		if len(pkgFiles) <= 0 {
+			continue
+		}
+		// Actually, we don't care about building the package. Only the parser
+		// have to succeed to read its documentation.
+		pkg, _ := ast.NewPackage(fileSet, pkgFiles, simpleImporter, nil)
+		envPkg := &envPackage{
+			platforms: []string{env.String()},
+			pkg:       pkg,
+			src:       newSource(dir, fileSet, pkg),
+		}
+		envsByFiles[key] = envPkg
+		fetched.envs = append(fetched.envs, envPkg)
 	}
-	// Parse all package's sourcecode
-	fileSet := token.NewFileSet()
-	filesData := map[string][]byte{}
-	pkgFiles := map[string]*ast.File{}
-	for _, file := range dir.Files {
-		if strings.HasSuffix(file.Name, ".go") {
-			gosrc.OverwriteLineComments(file.Data)
+	if len(fetched.envs) <= 0 {
+		return nil, &IndexError{
+			ImportPath: dir.ImportPath,
+			Status:     StatusNoGoFiles,
+			Err:        errors.New("no buildable Go source files"),
+			Warnings:   fetched.warnings,
 		}
-		filesData[file.Name] = file.Data
-		// TODO(alvivi): else { addReferences(references, file.Data) }
 	}
-	fileNames := append(bpkg.GoFiles, bpkg.CgoFiles...)
-	for _, fname := range fileNames {
-		pfile, err :=
-			parser.ParseFile(fileSet, fname, filesData[fname], parser.ParseComments)
-		if err != nil {
-			return nil, err
+	return fetched, nil
+}
+
+func (fetched *fetchedPackage) warn(warning string) {
+	fetched.warnings = appendUnique(fetched.warnings, warning)
+}
+
+// maxParseErrors is the number of syntax errors reported per file.
+const maxParseErrors = 5
+
+func parseWarning(fname string, err error) string {
+	// Scanner errors are already prefixed with their position
+	list, ok := err.(scanner.ErrorList)
+	if !ok {
+		return fmt.Sprintf("%s: %s", fname, err.Error())
+	}
+	msgs := []string{}
+	for i, e := range list {
+		if i >= maxParseErrors {
+			msgs = append(msgs, fmt.Sprintf("and %d more errors", len(list)-i))
+			break
 		}
-		pkgFiles[fname] = pfile
+		msgs = append(msgs, e.Error())
 	}
-	// Actually, we don't care about building the package. Only the parser have
-	// to succeed to read its documentation.
-	pkg, _ := ast.NewPackage(fileSet, pkgFiles, simpleImporter, nil)
-	return pkg, nil
+	return strings.Join(msgs, "; ")
+}
+
+// buildEnv is a GOOS/GOARCH pair.
+type buildEnv struct{ GOOS, GOARCH string }
+
+func (env buildEnv) String() string {
+	return env.GOOS + "/" + env.GOARCH
 }
 
-var buildEnvs = []struct{ GOOS, GOARCH string }{
+var buildEnvs = []buildEnv{
 	{"linux", "amd64"},
 	{"darwin", "amd64"},
 	{"windows", "amd64"},
 }
 
+// SetBuildEnvs sets the platforms, written as "goos/goarch", packages are
+// indexed for. The API of a package is the union of the APIs found in each
+// of them.
+func SetBuildEnvs(platforms []string) error {
+	envs := []buildEnv{}
+	for _, p := range platforms {
+		parts := strings.Split(strings.TrimSpace(p), "/")
+		if len(parts) != 2 || len(parts[0]) <= 0 || len(parts[1]) <= 0 {
+			return fmt.Errorf("Invalid platform %q", p)
+		}
+		envs = append(envs, buildEnv{parts[0], parts[1]})
+	}
+	if len(envs) <= 0 {
+		return errors.New("No platforms")
+	}
+	buildEnvs = envs
+	return nil
+}
+
+// BuildPlatforms returns the platforms packages are indexed for.
+func BuildPlatforms() []string {
+	ps := make([]string, len(buildEnvs))
+	for i, env := range buildEnvs {
+		ps[i] = env.String()
+	}
+	return ps
+}
+
 /*
 A stub importer implementation
 */
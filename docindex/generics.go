@@ -0,0 +1,81 @@
+package docindex
+
+import (
+	"go/ast"
+	"go/types"
+	"strings"
+)
+
+// TypeParam is a type parameter of a generic function or type.
+type TypeParam struct {
+	Name       string `json:"name"`
+	Constraint string `json:"constraint"`
+}
+
+func newTypeParams(fields *ast.FieldList) []TypeParam {
+	if fields == nil {
+		return nil
+	}
+	tps := []TypeParam{}
+	for _, field := range fields.List {
+		constraint := types.ExprString(field.Type)
+		for _, name := range field.Names {
+			tps = append(tps, TypeParam{Name: name.Name, Constraint: constraint})
+		}
+	}
+	return tps
+}
+
+// constraintTerms returns the searchable terms of the constraints of some
+// type parameters: each whole constraint plus every type named in it, both
+// qualified and unqualified. So "constraints.Ordered" is found by "Ordered",
+// and "~int | ~string" by "int".
+func constraintTerms(fields *ast.FieldList) []string {
+	if fields == nil {
+		return nil
+	}
+	terms := []string{}
+	for _, field := range fields.List {
+		terms = appendUnique(terms, types.ExprString(field.Type))
+		ast.Inspect(field.Type, func(n ast.Node) bool {
+			switch e := n.(type) {
+			case *ast.SelectorExpr:
+				terms = appendUnique(terms, types.ExprString(e))
+				terms = appendUnique(terms, e.Sel.Name)
+				return false
+			case *ast.Ident:
+				terms = appendUnique(terms, e.Name)
+			}
+			return true
+		})
+	}
+	return terms
+}
+
+// typeSpecParams returns the type parameters of the named type declared in
+// a group of declarations.
+func typeSpecParams(decl *ast.GenDecl, name string) *ast.FieldList {
+	for _, spec := range decl.Specs {
+		if s, ok := spec.(*ast.TypeSpec); ok && s.Name.Name == name {
+			return s.TypeParams
+		}
+	}
+	return nil
+}
+
+// formatTypeParams formats type parameters as they are written in Go, from
+// the stored names and constraints.
+func formatTypeParams(names, constraints []string) string {
+	if len(names) <= 0 || len(names) != len(constraints) {
+		return ""
+	}
+	parts := []string{}
+	for i, name := range names {
+		if i+1 < len(names) && constraints[i] == constraints[i+1] {
+			parts = append(parts, name)
+		} else {
+			parts = append(parts, name+" "+constraints[i])
+		}
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
@@ -0,0 +1,110 @@
+package docindex
+
+import (
+	"bytes"
+	"go/scanner"
+	"go/token"
+	"html"
+	"html/template"
+	"strings"
+)
+
+// SourceLine is a line of syntax highlighted sourcecode.
+type SourceLine struct {
+	Number int
+	HTML   template.HTML
+}
+
+// HighlightSource splits Go sourcecode in lines, wrapping keywords, comments
+// and literals in spans with a class for each of them.
+func HighlightSource(src []byte) []SourceLine {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var s scanner.Scanner
+	s.Init(file, src, nil, scanner.ScanComments)
+
+	buf := new(bytes.Buffer)
+	last := 0
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		// Automatically inserted semicolons are not part of the sourcecode
+		if tok == token.SEMICOLON && lit == "\n" {
+			continue
+		}
+		start := file.Offset(pos)
+		text := lit
+		if len(text) <= 0 {
+			text = tok.String()
+		}
+		end := start + len(text)
+		if start < last || end > len(src) {
+			continue
+		}
+		writeHighlight(buf, "", src[last:start])
+		writeHighlight(buf, tokenClass(tok), src[start:end])
+		last = end
+	}
+	writeHighlight(buf, "", src[last:])
+
+	rawLines := strings.Split(buf.String(), "\n")
+	if len(rawLines) > 1 && len(rawLines[len(rawLines)-1]) <= 0 {
+		rawLines = rawLines[:len(rawLines)-1]
+	}
+	lines := make([]SourceLine, len(rawLines))
+	for i, l := range rawLines {
+		lines[i] = SourceLine{Number: i + 1, HTML: template.HTML(l)}
+	}
+	return lines
+}
+
+// highlightFragment escapes a search result fragment, which bleve highlights
+// with the "html" style without escaping the indexed text, so only the mark
+// tags around the matches are kept as markup.
+func highlightFragment(fragment string) template.HTML {
+	marked := strings.Split(fragment, "<mark>")
+	for i, m := range marked {
+		texts := strings.Split(m, "</mark>")
+		for j, text := range texts {
+			texts[j] = html.EscapeString(text)
+		}
+		marked[i] = strings.Join(texts, "</mark>")
+	}
+	return template.HTML(strings.Join(marked, "<mark>"))
+}
+
+// writeHighlight writes an escaped piece of sourcecode, closing and reopening
+// its span around line breaks so each line is a well formed fragment.
+func writeHighlight(buf *bytes.Buffer, class string, text []byte) {
+	for i, l := range strings.Split(string(text), "\n") {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		if len(l) <= 0 {
+			continue
+		}
+		if len(class) > 0 {
+			buf.WriteString(`<span class="` + class + `">`)
+		}
+		buf.WriteString(html.EscapeString(l))
+		if len(class) > 0 {
+			buf.WriteString("</span>")
+		}
+	}
+}
+
+func tokenClass(tok token.Token) string {
+	switch {
+	case tok == token.COMMENT:
+		return "com"
+	case tok == token.STRING || tok == token.CHAR:
+		return "str"
+	case tok == token.INT || tok == token.FLOAT || tok == token.IMAG:
+		return "num"
+	case tok.IsKeyword():
+		return "kwd"
+	}
+	return ""
+}
@@ -1,8 +1,14 @@
 package docindex
 
 import (
-	"bytes"
+	"fmt"
+	"go/ast"
 	"go/doc"
+	"go/token"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/blevesearch/bleve"
 )
@@ -24,15 +30,68 @@ const (
 	VarKind DocKind = "v"
 	// TypeKind is the kind of a variable.
 	TypeKind DocKind = "t"
+	// CommandKind is the kind of a command, a main package.
+	CommandKind DocKind = "b"
 )
 
+// kindMapping is how documents of a kind are indexed.
+type kindMapping struct {
+	// docType is the name of the document mapping of the kind, which is the
+	// type documents of that kind report.
+	docType string
+	// boost is the factor the scores of matches of the kind are multiplied
+	// by, so they rank over the others. It is applied after the search, as
+	// query boosts would be scaled by how rare each kind is.
+	boost float64
+}
+
+// kindMappings is the registry of the kinds of documents of the index.
+var kindMappings = map[DocKind]kindMapping{
+	PackageKind: {"package", 1.5},
+	CommandKind: {"command", 1.5},
+	TypeKind:    {"type", 1.2},
+	FuncKind:    {"func", 1.0},
+	MethodKind:  {"method", 1.0},
+	ConstKind:   {"const", 0.8},
+	VarKind:     {"var", 0.8},
+}
+
 // Package ...
 // TODO(alvivi): doc this
 type Package struct {
-	Doc        string  `json:"doc"`
-	Name       string  `json:"name"`
-	ImportPath string  `json:"import"`
-	Kind       DocKind `json:"kind"`
+	Doc        string   `json:"doc"`
+	HTML       string   `json:"html"`
+	Code       []string `json:"code"`
+	Synopsis   string   `json:"synopsis"`
+	Name       string   `json:"name"`
+	ImportPath string   `json:"import"`
+	Host       string   `json:"host"`
+	Kind       DocKind  `json:"kind"`
+	Pos        Position `json:"pos"`
+
+	Deprecated  bool   `json:"deprecated"`
+	Deprecation string `json:"deprecation"`
+
+	Platforms Platforms `json:"platforms"`
+
+	Notes []Note `json:"notes"`
+
+	Refs []DocRef `json:"refs"`
+	URLs []string `json:"urls"`
+
+	// Completions are the lowercased names the entry is completed from.
+	Completions []string `json:"completions"`
+
+	// Indexed is when the package of the entry was fetched to be indexed.
+	Indexed time.Time `json:"indexed"`
+	// Inbound is the promotion tier of the entry by the doc links to it.
+	Inbound string `json:"inbound"`
+
+	// Binary is the name of the binary built from a command.
+	Binary string `json:"binary"`
+
+	Status   PackageStatus `json:"status"`
+	Warnings []string      `json:"warnings"`
 
 	Funcs  []*Func  `json:"funcs"`
 	Consts []*Value `json:"const"`
@@ -42,36 +101,50 @@ type Package struct {
 
 // NewPackage ...
 // TODO(alvivi): doc this
-func NewPackage(pkgDoc *doc.Package) *Package {
+func NewPackage(pkgDoc *doc.Package, src *Source) *Package {
 	pkg := new(Package)
 	pkg.Kind = PackageKind
 	pkg.Name = pkgDoc.Name
 	pkg.ImportPath = pkgDoc.ImportPath
-	buf := new(bytes.Buffer)
-	doc.ToHTML(buf, pkgDoc.Doc, nil)
-	pkg.Doc = removeDocSourcecode(buf.String())
+	pkg.Host = importHost(pkgDoc.ImportPath)
+	pkg.Completions = completionNames(pkgDoc.ImportPath, pkgDoc.Name)
+	pkg.Pos = src.PackagePosition()
+	docs := newDocRenderer(pkgDoc)
+	rendered, code := docs.renderProse(pkgDoc.Doc)
+	pkg.Doc, pkg.HTML, pkg.Code = rendered.text, rendered.html, code
+	pkg.Refs, pkg.URLs = rendered.refs, rendered.urls
+	pkg.Synopsis = pkgDoc.Synopsis(pkgDoc.Doc)
+	pkg.Deprecated, pkg.Deprecation = deprecationNotice(pkgDoc.Doc)
+	pkg.Notes = newNotes(pkgDoc)
+	if src != nil {
+		src.notes = pkgDoc.Notes
+		src.docs = docs
+	}
+	if pkgDoc.Name == "main" {
+		return newCommand(pkg, docs.render(pkgDoc.Doc))
+	}
 	// Top level functions
 	funcs := make([]*Func, len(pkgDoc.Funcs))
 	for i, fn := range pkgDoc.Funcs {
-		funcs[i] = NewFunction(pkg, fn)
+		funcs[i] = NewFunction(pkg, src, fn)
 	}
 	pkg.Funcs = funcs
 	// Top level constants
 	consts := []*Value{}
 	for _, c := range pkgDoc.Consts {
-		consts = append(consts, NewConsts(pkg, c)...)
+		consts = append(consts, NewConsts(pkg, src, c)...)
 	}
 	pkg.Consts = consts
 	// Top level variables
 	vars := []*Value{}
 	for _, v := range pkgDoc.Vars {
-		vars = append(vars, NewVars(pkg, v)...)
+		vars = append(vars, NewVars(pkg, src, v)...)
 	}
 	pkg.Vars = vars
 	// Type declarations
 	ts := make([]*Type, len(pkgDoc.Types))
 	for i, t := range pkgDoc.Types {
-		tt, fs := NewType(pkg, t)
+		tt, fs := NewType(pkg, src, t)
 		ts[i] = tt
 		pkg.Funcs = append(pkg.Funcs, fs...)
 	}
@@ -79,120 +152,353 @@ func NewPackage(pkgDoc *doc.Package) *Package {
 	return pkg
 }
 
-// Type ...
-// TODO(alvivi): doc this
+// newCommand turns the description of a main package into the description
+// of a command. Commands have no API, but their documentation is their usage
+// text, so preformatted blocks (like flag lists) are kept.
+func newCommand(pkg *Package, rendered renderedDoc) *Package {
+	pkg.Kind = CommandKind
+	pkg.Doc = rendered.text
+	pkg.Code = []string{}
+	pkg.Binary = commandName(pkg.ImportPath)
+	pkg.Name = pkg.Binary
+	pkg.Completions = completionNames(pkg.ImportPath, pkg.Binary)
+	pkg.Funcs = []*Func{}
+	pkg.Consts = []*Value{}
+	pkg.Vars = []*Value{}
+	pkg.Types = []*Type{}
+	return pkg
+}
+
+// commandName returns the name of the binary "go install" builds from a
+// command, which is the last element of its import path ignoring major
+// version suffixes.
+func commandName(importPath string) string {
+	name := path.Base(importPath)
+	if isMajorVersion(name) && path.Dir(importPath) != "." {
+		name = path.Base(path.Dir(importPath))
+	}
+	return name
+}
+
+// importHost returns the host of an import path, which is its first element.
+func importHost(importPath string) string {
+	return strings.SplitN(importPath, "/", 2)[0]
+}
+
+func isMajorVersion(elem string) bool {
+	if len(elem) < 2 || elem[0] != 'v' {
+		return false
+	}
+	for _, r := range elem[1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Type returns the name of the document mapping of the package.
 func (pkg Package) Type() string {
-	return "package"
+	return kindMappings[pkg.Kind].docType
 }
 
 // Func ...
 // TODO(alvivi): doc this
 type Func struct {
-	Doc        string  `json:"doc"`
-	Name       string  `json:"name"`
-	ImportPath string  `json:"import"`
-	Kind       DocKind `json:"kind"`
+	Doc        string   `json:"doc"`
+	HTML       string   `json:"html"`
+	Name       string   `json:"name"`
+	ImportPath string   `json:"import"`
+	Host       string   `json:"host"`
+	Kind       DocKind  `json:"kind"`
+	Receiver   string   `json:"recv"`
+	Pos        Position `json:"pos"`
+
+	Deprecated  bool   `json:"deprecated"`
+	Deprecation string `json:"deprecation"`
+
+	Platforms Platforms `json:"platforms"`
+
+	Notes []Note `json:"notes"`
+
+	Refs []DocRef `json:"refs"`
+	URLs []string `json:"urls"`
+
+	// Completions are the lowercased names the entry is completed from.
+	Completions []string `json:"completions"`
+
+	// Indexed is when the package of the entry was fetched to be indexed.
+	Indexed time.Time `json:"indexed"`
+	// Inbound is the promotion tier of the entry by the doc links to it.
+	Inbound string `json:"inbound"`
+
+	Generic     bool        `json:"generic"`
+	TypeParams  []TypeParam `json:"typeparams"`
+	Constraints []string    `json:"constraints"`
 }
 
 // NewFunction ...
 // TODO(alvivi): doc this
-func NewFunction(pkg *Package, fn *doc.Func) *Func {
-	return &Func{
-		Doc:        fn.Doc,
+func NewFunction(pkg *Package, src *Source, fn *doc.Func) *Func {
+	rendered := src.renderDoc(fn.Doc)
+	f := &Func{
+		Doc:        rendered.text,
+		HTML:       rendered.html,
 		Name:       fn.Name,
 		ImportPath: pkg.ImportPath,
+		Host:       pkg.Host,
 		Kind:       FuncKind,
+		Refs:       rendered.refs,
+		URLs:       rendered.urls,
+		Pos:        src.Position(fn.Decl.Pos()),
 	}
+	f.Completions = completionNames(fn.Name, pkg.Name+"."+fn.Name)
+	f.Deprecated, f.Deprecation = deprecationNotice(fn.Doc)
+	f.Notes = src.notesOf(fn.Decl)
+	f.setTypeParams(fn.Decl.Type.TypeParams)
+	return f
 }
 
 // NewMethod ...
 // TODO(alvivi): doc this
-func NewMethod(pkg *Package, fn *doc.Func) *Func {
-	return &Func{
-		Doc:        fn.Doc,
+func NewMethod(pkg *Package, src *Source, fn *doc.Func) *Func {
+	rendered := src.renderDoc(fn.Doc)
+	m := &Func{
+		Doc:        rendered.text,
+		HTML:       rendered.html,
 		Name:       fn.Name,
 		ImportPath: pkg.ImportPath,
+		Host:       pkg.Host,
 		Kind:       MethodKind,
+		Refs:       rendered.refs,
+		URLs:       rendered.urls,
+		Receiver:   strings.TrimPrefix(fn.Recv, "*"),
+		Pos:        src.Position(fn.Decl.Pos()),
 	}
+	m.Completions = completionNames(fn.Name, m.Receiver+"."+fn.Name,
+		pkg.Name+"."+m.Receiver+"."+fn.Name)
+	m.Deprecated, m.Deprecation = deprecationNotice(fn.Doc)
+	m.Notes = src.notesOf(fn.Decl)
+	return m
 }
 
-// Type ...
-// TODO(alvivi): doc this
+func (fn *Func) setTypeParams(fields *ast.FieldList) {
+	fn.TypeParams = newTypeParams(fields)
+	fn.Constraints = constraintTerms(fields)
+	fn.Generic = len(fn.TypeParams) > 0
+}
+
+// ID returns the document identifier of the function. Methods are qualified
+// by their receiver, since several types can share a method name.
+func (fn Func) ID() string {
+	if len(fn.Receiver) > 0 {
+		return fmt.Sprintf("%s.%s.%s", fn.ImportPath, fn.Receiver, fn.Name)
+	}
+	return fmt.Sprintf("%s.%s", fn.ImportPath, fn.Name)
+}
+
+// Type returns the name of the document mapping of the function.
 func (fn Func) Type() string {
-	return "func"
+	return kindMappings[fn.Kind].docType
 }
 
 // Value represents top level constants and variables.
 type Value struct {
-	Doc        string  `json:"doc"`
-	Name       string  `json:"name"`
-	ImportPath string  `json:"import"`
-	Kind       DocKind `json:"kind"`
+	Doc        string   `json:"doc"`
+	HTML       string   `json:"html"`
+	Name       string   `json:"name"`
+	ImportPath string   `json:"import"`
+	Host       string   `json:"host"`
+	Kind       DocKind  `json:"kind"`
+	Pos        Position `json:"pos"`
+
+	Deprecated  bool   `json:"deprecated"`
+	Deprecation string `json:"deprecation"`
+
+	Platforms Platforms `json:"platforms"`
+
+	Notes []Note `json:"notes"`
+
+	Refs []DocRef `json:"refs"`
+	URLs []string `json:"urls"`
+
+	// Completions are the lowercased names the entry is completed from.
+	Completions []string `json:"completions"`
+
+	// Indexed is when the package of the entry was fetched to be indexed.
+	Indexed time.Time `json:"indexed"`
+	// Inbound is the promotion tier of the entry by the doc links to it.
+	Inbound string `json:"inbound"`
 }
 
 // NewConsts ...
 // TODO(alvivi): doc this
-func NewConsts(pkg *Package, v *doc.Value) []*Value {
-	return newValues(pkg, v, ConstKind)
+func NewConsts(pkg *Package, src *Source, v *doc.Value) []*Value {
+	return newValues(pkg, src, v, ConstKind)
 }
 
 // NewVars ...
 // TODO(alvivi): doc this
-func NewVars(pkg *Package, v *doc.Value) []*Value {
-	return newValues(pkg, v, VarKind)
+func NewVars(pkg *Package, src *Source, v *doc.Value) []*Value {
+	return newValues(pkg, src, v, VarKind)
 }
 
-// Type ...
-// TODO(alvivi): doc this
+// ID returns the document identifier of the value.
+func (v Value) ID() string {
+	return fmt.Sprintf("%s.%s", v.ImportPath, v.Name)
+}
+
+// Type returns the name of the document mapping of the value.
 func (v Value) Type() string {
-	return "value"
+	return kindMappings[v.Kind].docType
 }
 
 // Type represents top level type declaration.
 type Type struct {
-	Doc        string  `json:"doc"`
-	Name       string  `json:"name"`
-	ImportPath string  `json:"import"`
-	Kind       DocKind `json:"kind"`
+	Doc        string   `json:"doc"`
+	HTML       string   `json:"html"`
+	Name       string   `json:"name"`
+	ImportPath string   `json:"import"`
+	Host       string   `json:"host"`
+	Kind       DocKind  `json:"kind"`
+	Pos        Position `json:"pos"`
+
+	Deprecated  bool   `json:"deprecated"`
+	Deprecation string `json:"deprecation"`
+
+	Platforms Platforms `json:"platforms"`
+
+	Notes []Note `json:"notes"`
+
+	Refs []DocRef `json:"refs"`
+	URLs []string `json:"urls"`
+
+	// Completions are the lowercased names the entry is completed from.
+	Completions []string `json:"completions"`
+
+	// Indexed is when the package of the entry was fetched to be indexed.
+	Indexed time.Time `json:"indexed"`
+	// Inbound is the promotion tier of the entry by the doc links to it.
+	Inbound string `json:"inbound"`
+
+	Generic     bool        `json:"generic"`
+	TypeParams  []TypeParam `json:"typeparams"`
+	Constraints []string    `json:"constraints"`
 
 	Methods []Func `json:"methods"`
 }
 
 // NewType ...
 // TODO(alvivi): doc this
-func NewType(pkg *Package, docType *doc.Type) (*Type, []*Func) {
+func NewType(pkg *Package, src *Source, docType *doc.Type) (*Type, []*Func) {
 	t := new(Type)
-	t.Doc = docType.Doc
+	rendered := src.renderDoc(docType.Doc)
+	t.Doc, t.HTML = rendered.text, rendered.html
+	t.Refs, t.URLs = rendered.refs, rendered.urls
 	t.Name = docType.Name
 	t.ImportPath = pkg.ImportPath
+	t.Host = pkg.Host
 	t.Kind = TypeKind
+	t.Completions = completionNames(docType.Name, pkg.Name+"."+docType.Name)
+	t.Pos = src.Position(specPos(docType.Decl, docType.Name))
+	t.Deprecated, t.Deprecation = deprecationNotice(docType.Doc)
+	t.Notes = src.notesOf(docType.Decl)
+	tparams := typeSpecParams(docType.Decl, docType.Name)
+	t.TypeParams = newTypeParams(tparams)
+	t.Constraints = constraintTerms(tparams)
+	t.Generic = len(t.TypeParams) > 0
 	fns := make([]*Func, len(docType.Funcs)+len(docType.Methods))
 	for i, f := range docType.Funcs {
-		fns[i] = NewFunction(pkg, f)
+		fns[i] = NewFunction(pkg, src, f)
 	}
 	for i, m := range docType.Methods {
-		fns[i+len(docType.Funcs)] = NewMethod(pkg, m)
+		fns[i+len(docType.Funcs)] = NewMethod(pkg, src, m)
 	}
 	return t, fns
 }
 
+// ID returns the document identifier of the type.
+func (v Type) ID() string {
+	return fmt.Sprintf("%s.%s", v.ImportPath, v.Name)
+}
+
 // Type is the most recurisve method out there.
 func (v Type) Type() string {
-	return "type"
+	return kindMappings[v.Kind].docType
+}
+
+// MappingVersion is the version of the index mapping built by this package.
+// It has to be increased on every change of buildDefaultMapping, so indexes
+// built with an older mapping are detected.
+const MappingVersion = 8
+
+var mappingVersionKey = []byte("ging-mapping-version")
+
+// MappingVersionError is returned when an index was built with a different
+// mapping version.
+type MappingVersionError struct {
+	Path  string
+	Found int
+}
+
+func (e *MappingVersionError) Error() string {
+	return fmt.Sprintf("Index %s was built with mapping version %d, but version %d is required",
+		e.Path, e.Found, MappingVersion)
 }
 
 // OpenOrCreateIndex ...
 // TODO(alvivi): doc this
-func OpenOrCreateIndex(indexPath string) (bleve.Index, error) {
-	idx, err := bleve.Open(indexPath)
+//
+// New indexes are split in shards as set by SetShardConfig.
+//
+// Opening an index built with another mapping version fails with a
+// MappingVersionError, but the index is returned anyway, so it can be served
+// while a new one is built.
+func OpenOrCreateIndex(indexPath string) (*Index, error) {
+	idx, err := openShards(indexPath)
 	if err == nil {
+		version, err := indexMappingVersion(idx)
+		if err != nil {
+			idx.Close()
+			return nil, err
+		}
+		if version != MappingVersion {
+			return idx, &MappingVersionError{Path: indexPath, Found: version}
+		}
 		return idx, nil
 	}
+	return newIndex(indexPath)
+}
+
+// newIndex creates an index with the current mapping.
+func newIndex(indexPath string) (*Index, error) {
 	mapping, err := buildDefaultMapping()
 	if err != nil {
 		return nil, err
 	}
-	return bleve.New(indexPath, mapping)
+	idx, err := createShards(indexPath, mapping)
+	if err != nil {
+		return nil, err
+	}
+	err = idx.SetInternal(mappingVersionKey, []byte(strconv.Itoa(MappingVersion)))
+	if err != nil {
+		idx.Close()
+		return nil, err
+	}
+	return idx, nil
+}
+
+// indexMappingVersion returns the mapping version of an index. Indexes built
+// before mappings were versioned are version 0.
+func indexMappingVersion(idx bleve.Index) (int, error) {
+	data, err := idx.GetInternal(mappingVersionKey)
+	if err != nil {
+		return 0, err
+	}
+	if data == nil {
+		return 0, nil
+	}
+	return strconv.Atoi(string(data))
 }
 
 func buildDefaultMapping() (*bleve.IndexMapping, error) {
@@ -200,31 +506,116 @@ func buildDefaultMapping() (*bleve.IndexMapping, error) {
 	docFieldMapping := bleve.NewTextFieldMapping()
 	docFieldMapping.Analyzer = "doc"
 
+	// a generic reusable mapping for code snippets
+	codeFieldMapping := bleve.NewTextFieldMapping()
+	codeFieldMapping.Analyzer = "code"
+
 	// a generic reusable mapping for keyword text
 	keywordFieldMapping := bleve.NewTextFieldMapping()
 	keywordFieldMapping.Analyzer = "keyword"
 
+	// a mapping for keywords only used to filter, complete or boost, which
+	// are kept out of the _all field so they do not match text queries
+	filterFieldMapping := bleve.NewTextFieldMapping()
+	filterFieldMapping.Analyzer = "keyword"
+	filterFieldMapping.IncludeInAll = false
+
 	// a generic reusable mapping which only stores (but no index) a text
 	noindexTextFieldMapping := bleve.NewTextFieldMapping()
 	noindexTextFieldMapping.Store = true
 	noindexTextFieldMapping.Index = false
 
+	// a generic reusable mapping which only stores (but no index) a number
+	noindexNumericFieldMapping := bleve.NewNumericFieldMapping()
+	noindexNumericFieldMapping.Store = true
+	noindexNumericFieldMapping.Index = false
+
+	// a mapping for source positions
+	posMapping := bleve.NewDocumentStaticMapping()
+	posMapping.AddFieldMappingsAt("file", noindexTextFieldMapping)
+	posMapping.AddFieldMappingsAt("line", noindexNumericFieldMapping)
+	posMapping.AddFieldMappingsAt("source", noindexTextFieldMapping)
+
+	// a mapping for indexing times
+	dateTimeFieldMapping := bleve.NewDateTimeFieldMapping()
+
+	// a generic reusable mapping for flags
+	boolFieldMapping := bleve.NewBooleanFieldMapping()
+
+	// a mapping for the platforms an entry is available on
+	platformsMapping := bleve.NewDocumentStaticMapping()
+	platformsMapping.AddFieldMappingsAt("all", noindexTextFieldMapping)
+	platformsMapping.AddFieldMappingsAt("goos", keywordFieldMapping)
+	platformsMapping.AddFieldMappingsAt("goarch", keywordFieldMapping)
+
+	// a mapping for type parameters
+	typeParamMapping := bleve.NewDocumentStaticMapping()
+	typeParamMapping.AddFieldMappingsAt("name", keywordFieldMapping)
+	typeParamMapping.AddFieldMappingsAt("constraint", noindexTextFieldMapping)
+
+	// a mapping for notes
+	noteMapping := bleve.NewDocumentStaticMapping()
+	noteMapping.AddFieldMappingsAt("marker", keywordFieldMapping)
+	noteMapping.AddFieldMappingsAt("uid", keywordFieldMapping)
+	noteMapping.AddFieldMappingsAt("body", noindexTextFieldMapping)
+	noteMapping.AddFieldMappingsAt("text", docFieldMapping)
+
+	// a mapping for doc links
+	refMapping := bleve.NewDocumentStaticMapping()
+	refMapping.AddFieldMappingsAt("target", filterFieldMapping)
+	refMapping.AddFieldMappingsAt("text", noindexTextFieldMapping)
+	refMapping.AddFieldMappingsAt("link", noindexTextFieldMapping)
+
 	// a generinc reusable entry mapping
 	entryMapping := bleve.NewDocumentStaticMapping()
 	entryMapping.AddFieldMappingsAt("name", keywordFieldMapping)
+	entryMapping.AddFieldMappingsAt("import", keywordFieldMapping)
+	entryMapping.AddFieldMappingsAt("host", filterFieldMapping)
 	entryMapping.AddFieldMappingsAt("doc", docFieldMapping)
-	entryMapping.AddFieldMappingsAt("kind", noindexTextFieldMapping)
+	entryMapping.AddFieldMappingsAt("html", noindexTextFieldMapping)
+	entryMapping.AddFieldMappingsAt("kind", filterFieldMapping)
+	entryMapping.AddFieldMappingsAt("recv", noindexTextFieldMapping)
+	entryMapping.AddSubDocumentMapping("pos", posMapping)
+	entryMapping.AddFieldMappingsAt("deprecated", boolFieldMapping)
+	entryMapping.AddFieldMappingsAt("deprecation", noindexTextFieldMapping)
+	entryMapping.AddSubDocumentMapping("platforms", platformsMapping)
+	entryMapping.AddSubDocumentMapping("notes", noteMapping)
+	entryMapping.AddSubDocumentMapping("refs", refMapping)
+	entryMapping.AddFieldMappingsAt("urls", noindexTextFieldMapping)
+	entryMapping.AddFieldMappingsAt("generic", boolFieldMapping)
+	entryMapping.AddSubDocumentMapping("typeparams", typeParamMapping)
+	entryMapping.AddFieldMappingsAt("constraints", keywordFieldMapping)
+	entryMapping.AddFieldMappingsAt("completions", filterFieldMapping)
+	entryMapping.AddFieldMappingsAt("indexed", dateTimeFieldMapping)
+	entryMapping.AddFieldMappingsAt("inbound", filterFieldMapping)
 
 	// Package Mapping
 	packageMapping := bleve.NewDocumentStaticMapping()
 	packageMapping.AddFieldMappingsAt("name", keywordFieldMapping)
-	// TODO(alvivi): ImportPath must be indexed, but requires a custom analayzer
-	// that removes the host.
-	packageMapping.AddFieldMappingsAt("import", noindexTextFieldMapping)
+	// TODO(alvivi): ImportPath must be searchable, but requires a custom
+	// analayzer that removes the host. Right now it is only used by filters.
+	packageMapping.AddFieldMappingsAt("import", keywordFieldMapping)
+	packageMapping.AddFieldMappingsAt("host", filterFieldMapping)
 	packageMapping.AddFieldMappingsAt("doc", docFieldMapping)
-	packageMapping.AddFieldMappingsAt("kind", noindexTextFieldMapping)
+	packageMapping.AddFieldMappingsAt("html", noindexTextFieldMapping)
+	packageMapping.AddFieldMappingsAt("synopsis", docFieldMapping)
+	packageMapping.AddFieldMappingsAt("code", codeFieldMapping)
+	packageMapping.AddFieldMappingsAt("kind", filterFieldMapping)
+	packageMapping.AddSubDocumentMapping("pos", posMapping)
+	packageMapping.AddFieldMappingsAt("deprecated", boolFieldMapping)
+	packageMapping.AddFieldMappingsAt("deprecation", noindexTextFieldMapping)
+	packageMapping.AddSubDocumentMapping("platforms", platformsMapping)
+	packageMapping.AddSubDocumentMapping("notes", noteMapping)
+	packageMapping.AddSubDocumentMapping("refs", refMapping)
+	packageMapping.AddFieldMappingsAt("urls", noindexTextFieldMapping)
+	packageMapping.AddFieldMappingsAt("binary", keywordFieldMapping)
+	packageMapping.AddFieldMappingsAt("completions", filterFieldMapping)
+	packageMapping.AddFieldMappingsAt("indexed", dateTimeFieldMapping)
+	packageMapping.AddFieldMappingsAt("inbound", filterFieldMapping)
+	packageMapping.AddFieldMappingsAt("status", keywordFieldMapping)
+	packageMapping.AddFieldMappingsAt("warnings", noindexTextFieldMapping)
 	packageMapping.AddSubDocumentMapping("funcs", entryMapping)
-	packageMapping.AddSubDocumentMapping("consts", entryMapping)
+	packageMapping.AddSubDocumentMapping("const", entryMapping)
 	packageMapping.AddSubDocumentMapping("vars", entryMapping)
 	packageMapping.AddSubDocumentMapping("types", entryMapping)
 
@@ -233,30 +624,82 @@ func buildDefaultMapping() (*bleve.IndexMapping, error) {
 	err := indexMapping.AddCustomAnalyzer("doc",
 		map[string]interface{}{
 			"type":          "custom",
-			"char_filters":  []string{"html"},
 			"tokenizer":     "whitespace",
 			"token_filters": []string{"to_lower", "stop_en"},
 		})
 	if err != nil {
 		return nil, err
 	}
-	indexMapping.AddDocumentMapping("package", packageMapping)
-	indexMapping.AddDocumentMapping("func", entryMapping)
-	indexMapping.AddDocumentMapping("const", entryMapping)
-	indexMapping.AddDocumentMapping("vars", entryMapping)
-	indexMapping.AddDocumentMapping("types", entryMapping)
+	err = indexMapping.AddCustomTokenizer("identifier",
+		map[string]interface{}{
+			"type":   "regexp",
+			"regexp": `[\p{L}_][\p{L}\p{N}_]*`,
+		})
+	if err != nil {
+		return nil, err
+	}
+	err = indexMapping.AddCustomAnalyzer("code",
+		map[string]interface{}{
+			"type":          "custom",
+			"tokenizer":     "identifier",
+			"token_filters": []string{"to_lower"},
+		})
+	if err != nil {
+		return nil, err
+	}
+	for kind, km := range kindMappings {
+		if kind == PackageKind || kind == CommandKind {
+			indexMapping.AddDocumentMapping(km.docType, packageMapping)
+		} else {
+			indexMapping.AddDocumentMapping(km.docType, entryMapping)
+		}
+	}
+	// Every document has a type of the registry, anything else is a bug
+	indexMapping.DefaultMapping = bleve.NewDocumentDisabledMapping()
 	return indexMapping, nil
 }
 
-func newValues(pkg *Package, value *doc.Value, t DocKind) []*Value {
+func newValues(pkg *Package, src *Source, value *doc.Value, t DocKind) []*Value {
+	deprecated, deprecation := deprecationNotice(value.Doc)
+	notes := src.notesOf(value.Decl)
+	rendered := src.renderDoc(value.Doc)
 	vs := make([]*Value, len(value.Names))
 	for i, n := range value.Names {
 		vs[i] = &Value{
-			Doc:        value.Doc,
-			Name:       n,
-			ImportPath: pkg.ImportPath,
-			Kind:       t,
+			Doc:         rendered.text,
+			HTML:        rendered.html,
+			Refs:        rendered.refs,
+			URLs:        rendered.urls,
+			Name:        n,
+			ImportPath:  pkg.ImportPath,
+			Host:        pkg.Host,
+			Kind:        t,
+			Pos:         src.Position(specPos(value.Decl, n)),
+			Deprecated:  deprecated,
+			Deprecation: deprecation,
+			Notes:       notes,
+			Completions: completionNames(n, pkg.Name+"."+n),
 		}
 	}
 	return vs
 }
+
+// specPos returns the position of the name declared in a group of
+// declarations, or the position of the whole group if not found.
+func specPos(decl *ast.GenDecl, name string) token.Pos {
+	for _, spec := range decl.Specs {
+		switch s := spec.(type) {
+		case *ast.TypeSpec:
+			if s.Name.Name == name {
+				return s.Name.Pos()
+			}
+		case *ast.ValueSpec:
+			for _, n := range s.Names {
+				if n.Name == name {
+					return n.Pos()
+				}
+			}
+		}
+	}
+	return decl.Pos()
+}
@@ -0,0 +1,127 @@
+package docindex
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+)
+
+func newTestIndex(t *testing.T) bleve.Index {
+	mapping, err := buildDefaultMapping()
+	if err != nil {
+		t.Fatalf("Error building the mapping: %s", err)
+	}
+	index, err := bleve.NewMemOnly(mapping)
+	if err != nil {
+		t.Fatalf("Error creating the index: %s", err)
+	}
+	return index
+}
+
+// countHits returns the number of documents matching a query.
+func countHits(t *testing.T, index bleve.Index, query bleve.Query) uint64 {
+	sr, err := index.Search(bleve.NewSearchRequestOptions(query, 0, 0, false))
+	if err != nil {
+		t.Fatalf("Error searching: %s", err)
+	}
+	return sr.Total
+}
+
+// countDocHits returns whether the document with the given identifier
+// matches a query, as a number of hits.
+func countDocHits(t *testing.T, index bleve.Index, id string, query bleve.Query) uint64 {
+	return countHits(t, index, bleve.NewConjunctionQuery([]bleve.Query{
+		bleve.NewDocIDQuery([]string{id}),
+		query,
+	}))
+}
+
+type unregisteredDoc struct {
+	Name string `json:"name"`
+	Doc  string `json:"doc"`
+}
+
+func (unregisteredDoc) Type() string {
+	return "unregistered"
+}
+
+func TestKindMappings(t *testing.T) {
+	index := newTestIndex(t)
+	defer index.Close()
+
+	const importPath = "example.com/widget"
+	parser := &Func{Name: "Parse", ImportPath: importPath, Kind: FuncKind,
+		Doc: "Parse reads Widgets from a file."}
+	method := &Func{Name: "Close", Receiver: "Parser", ImportPath: importPath, Kind: MethodKind,
+		Doc: "Close releases the Widgets of the parser."}
+	constant := &Value{Name: "MaxDepth", ImportPath: importPath, Kind: ConstKind,
+		Doc: "MaxDepth limits how Widgets nest."}
+	variable := &Value{Name: "ErrSyntax", ImportPath: importPath, Kind: VarKind,
+		Doc: "ErrSyntax is returned for Widgets with syntax errors."}
+	typ := &Type{Name: "Parser", ImportPath: importPath, Kind: TypeKind,
+		Doc: "Parser reads Widgets from files."}
+	pkg := &Package{Name: "Widget", ImportPath: importPath, Kind: PackageKind,
+		Doc: "Package widget parses Widgets from files."}
+	docs := []struct {
+		id   string
+		name string
+		kind DocKind
+		data interface{}
+	}{
+		{pkg.ImportPath, pkg.Name, PackageKind, pkg},
+		{parser.ID(), parser.Name, FuncKind, parser},
+		{method.ID(), method.Name, MethodKind, method},
+		{constant.ID(), constant.Name, ConstKind, constant},
+		{variable.ID(), variable.Name, VarKind, variable},
+		{typ.ID(), typ.Name, TypeKind, typ},
+	}
+	for _, d := range docs {
+		err := index.Index(d.id, d.data)
+		if err != nil {
+			t.Fatalf("Error indexing %s: %s", d.id, err)
+		}
+	}
+
+	for _, d := range docs {
+		docType := kindMappings[d.kind].docType
+		// Names are keywords, so they are only found as written
+		if n := countDocHits(t, index, d.id, bleve.NewTermQuery(d.name).SetField("name")); n != 1 {
+			t.Errorf("%s %s: name %q not indexed as a keyword", docType, d.id, d.name)
+		}
+		if n := countDocHits(t, index, d.id, bleve.NewTermQuery(strings.ToLower(d.name)).SetField("name")); n != 0 {
+			t.Errorf("%s %s: name %q analyzed as text", docType, d.id, d.name)
+		}
+		// Docs go through the doc analyzer, which lowercases words
+		if n := countDocHits(t, index, d.id, bleve.NewTermQuery("widgets").SetField("doc")); n != 1 {
+			t.Errorf("%s %s: doc not analyzed with the doc analyzer", docType, d.id)
+		}
+		if n := countDocHits(t, index, d.id, bleve.NewTermQuery("Widgets").SetField("doc")); n != 0 {
+			t.Errorf("%s %s: doc indexed as written", docType, d.id)
+		}
+		if n := countDocHits(t, index, d.id, bleve.NewTermQuery(string(d.kind)).SetField("kind")); n != 1 {
+			t.Errorf("%s %s: kind %q not indexed", docType, d.id, d.kind)
+		}
+	}
+
+	// Kinds are filters, not text
+	if n := countHits(t, index, bleve.NewMatchQuery(string(FuncKind))); n != 0 {
+		t.Errorf("kind found by text queries in %d documents", n)
+	}
+}
+
+func TestUnregisteredTypeNotIndexed(t *testing.T) {
+	index := newTestIndex(t)
+	defer index.Close()
+
+	err := index.Index("unregistered", unregisteredDoc{Name: "Ghost", Doc: "Ghost haunts Widgets at night."})
+	if err != nil {
+		t.Fatalf("Error indexing: %s", err)
+	}
+	if n := countHits(t, index, bleve.NewTermQuery("Ghost").SetField("name")); n != 0 {
+		t.Errorf("unregistered document found by name")
+	}
+	if n := countHits(t, index, bleve.NewTermQuery("widgets").SetField("doc")); n != 0 {
+		t.Errorf("unregistered document found by doc")
+	}
+}
@@ -0,0 +1,86 @@
+package docindex
+
+import (
+	"go/parser"
+	"go/token"
+	"regexp"
+	"strings"
+
+	"github.com/golang/gddo/gosrc"
+)
+
+var spdxPat = regexp.MustCompile(`SPDX-License-Identifier:\s*([\w.+-]+(?:\s+(?:OR|AND|WITH)\s+[\w.+-]+)*)`)
+
+// licensePats are phrases which identify the most common licenses. Longer
+// names come first, so "GNU Lesser General Public License" is not taken for
+// the GPL.
+var licensePats = []struct {
+	name    string
+	phrases []string
+}{
+	{"Apache-2.0", []string{"Apache License, Version 2.0", "Apache License Version 2.0"}},
+	{"MPL-2.0", []string{"Mozilla Public License, v. 2.0", "Mozilla Public License Version 2.0"}},
+	{"AGPL-3.0", []string{"GNU Affero General Public License"}},
+	{"LGPL", []string{"GNU Lesser General Public License", "GNU Library General Public License"}},
+	{"GPL", []string{"GNU General Public License"}},
+	{"MIT", []string{"Permission is hereby granted, free of charge", "MIT License"}},
+	{"BSD", []string{"Redistribution and use in source and binary forms", "BSD-style license"}},
+	{"Unlicense", []string{"This is free and unencumbered software"}},
+}
+
+// detectLicense guesses the license of a package from its license files,
+// if they were fetched, or from the comments heading its Go files.
+func detectLicense(dir *gosrc.Directory) string {
+	texts := []string{}
+	for _, file := range dir.Files {
+		name := strings.ToUpper(file.Name)
+		if strings.HasPrefix(name, "LICENSE") || strings.HasPrefix(name, "LICENCE") ||
+			strings.HasPrefix(name, "COPYING") {
+			texts = append(texts, string(file.Data))
+		}
+	}
+	for _, file := range dir.Files {
+		if strings.HasSuffix(file.Name, ".go") {
+			texts = append(texts, fileHeader(file.Name, file.Data))
+		}
+	}
+	for _, text := range texts {
+		if license := licenseOf(text); len(license) > 0 {
+			return license
+		}
+	}
+	return ""
+}
+
+// fileHeader returns the comments of a Go file before its package clause.
+func fileHeader(name string, data []byte) string {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, name, data, parser.ParseComments|parser.PackageClauseOnly)
+	if err != nil || file == nil {
+		return ""
+	}
+	parts := []string{}
+	for _, group := range file.Comments {
+		if group.Pos() >= file.Package {
+			break
+		}
+		parts = append(parts, group.Text())
+	}
+	return strings.Join(parts, "\n")
+}
+
+func licenseOf(text string) string {
+	if m := spdxPat.FindStringSubmatch(text); m != nil {
+		return m[1]
+	}
+	// License texts are often wrapped at any word
+	text = strings.Join(strings.Fields(text), " ")
+	for _, pat := range licensePats {
+		for _, phrase := range pat.phrases {
+			if strings.Contains(text, phrase) {
+				return pat.name
+			}
+		}
+	}
+	return ""
+}
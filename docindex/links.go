@@ -0,0 +1,112 @@
+package docindex
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// LinkTemplate describes where search results of the packages under an
+// import path prefix point to. Package is used for package results and Symbol
+// for everything else. Both may contain the placeholders {import}, {symbol},
+// {receiver} and {version}.
+type LinkTemplate struct {
+	Prefix  string `json:"prefix"`
+	Package string `json:"package"`
+	Symbol  string `json:"symbol"`
+}
+
+var defaultLinkTemplate = LinkTemplate{
+	Package: "http://godoc.org/{import}",
+	Symbol:  "http://godoc.org/{import}#{symbol}",
+}
+
+var linkTemplates []LinkTemplate
+
+// SetLinkTemplates replaces the link templates used to build search result
+// links. Packages not matching any prefix keep linking to godoc.org.
+func SetLinkTemplates(templates []LinkTemplate) {
+	ts := make([]LinkTemplate, len(templates))
+	copy(ts, templates)
+	// The longest prefix wins, so it has to be checked first
+	sort.SliceStable(ts, func(i, j int) bool {
+		return len(ts[i].Prefix) > len(ts[j].Prefix)
+	})
+	linkTemplates = ts
+}
+
+// LoadLinkTemplates reads a JSON list of link templates from r and sets them
+// as the current ones.
+func LoadLinkTemplates(r io.Reader) error {
+	templates := []LinkTemplate{}
+	err := json.NewDecoder(r).Decode(&templates)
+	if err != nil {
+		return err
+	}
+	SetLinkTemplates(templates)
+	return nil
+}
+
+func linkTemplateFor(importPath string) LinkTemplate {
+	for _, t := range linkTemplates {
+		if hasPathPrefix(importPath, t.Prefix) {
+			return t
+		}
+	}
+	return defaultLinkTemplate
+}
+
+// buildLink returns the link of a documentation entry. receiver is only
+// meaningful for methods.
+func buildLink(kind DocKind, importPath, name, receiver string) string {
+	tmpl := linkTemplateFor(importPath)
+	pattern := tmpl.Symbol
+	symbol := name
+	switch kind {
+	case PackageKind, CommandKind:
+		pattern = tmpl.Package
+		symbol = ""
+	case MethodKind:
+		if len(receiver) > 0 {
+			symbol = receiver + "." + name
+		}
+	}
+	if len(pattern) <= 0 {
+		pattern = tmpl.Package
+	}
+	return strings.NewReplacer(
+		"{import}", importPath,
+		"{symbol}", symbol,
+		"{receiver}", receiver,
+		"{version}", packageVersion(importPath),
+	).Replace(pattern)
+}
+
+func hasPathPrefix(importPath, prefix string) bool {
+	if !strings.HasPrefix(importPath, prefix) {
+		return false
+	}
+	return len(prefix) == 0 ||
+		len(importPath) == len(prefix) ||
+		strings.HasSuffix(prefix, "/") ||
+		importPath[len(prefix)] == '/'
+}
+
+var versionPats = []*regexp.Regexp{
+	regexp.MustCompile(`^gopkg\.in/(?:[^/]+/)?[^/]+\.(v\d+)(?:/|$)`),
+	regexp.MustCompile(`/(v\d+)(?:/|$)`),
+}
+
+// packageVersion guesses the major version of a package from its import
+// path, which is the only version information available so far.
+func packageVersion(importPath string) string {
+	for _, pat := range versionPats {
+		m := pat.FindStringSubmatch(importPath)
+		if m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,131 @@
+package docindex
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// StatusFailed is the status of a package which could not be fetched or
+// indexed.
+const StatusFailed PackageStatus = "failed"
+
+var packagesBucket = []byte("packages")
+
+// PackageRecord is what Ging knows about an indexed package apart from its
+// documentation.
+type PackageRecord struct {
+	ImportPath string `json:"importPath"`
+	// FetchedAt is when the package was last fetched, and CheckedAt when it
+	// was last looked for changes.
+	FetchedAt time.Time `json:"fetchedAt"`
+	CheckedAt time.Time `json:"checkedAt"`
+	// Failures is the number of failed attempts since the last successful
+	// one, and Error the error of the last of them. A failed attempt keeps
+	// the status of the indexed version, if there is one.
+	Failures  int           `json:"failures"`
+	Etag      string        `json:"etag"`
+	VCS       string        `json:"vcs"`
+	BrowseURL string        `json:"browseURL"`
+	Status    PackageStatus `json:"status"`
+	Error     string        `json:"error"`
+	License   string        `json:"license"`
+	Version   string        `json:"version"`
+	Warnings  []string      `json:"warnings"`
+}
+
+// isIndexed reports whether the package was indexed by its last successful
+// attempt.
+func (rec *PackageRecord) isIndexed() bool {
+	return rec.Status == StatusIndexed || rec.Status == StatusPartial
+}
+
+// MetaStore keeps the package records in a bolt database, next to the
+// documentation index.
+type MetaStore struct {
+	db *bolt.DB
+}
+
+// OpenMetaStore opens (or creates) the metadata store at path.
+func OpenMetaStore(path string) (*MetaStore, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(packagesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &MetaStore{db: db}, nil
+}
+
+// Close closes the store.
+func (meta *MetaStore) Close() error {
+	return meta.db.Close()
+}
+
+// Put stores the record of a package, replacing the previous one.
+func (meta *MetaStore) Put(rec *PackageRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return meta.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(packagesBucket).Put([]byte(rec.ImportPath), data)
+	})
+}
+
+// Get returns the record of a package, or ErrPackageNotFound.
+func (meta *MetaStore) Get(importPath string) (*PackageRecord, error) {
+	rec := new(PackageRecord)
+	err := meta.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(packagesBucket).Get([]byte(importPath))
+		if data == nil {
+			return ErrPackageNotFound
+		}
+		return json.Unmarshal(data, rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Delete removes the record of a package.
+func (meta *MetaStore) Delete(importPath string) error {
+	return meta.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(packagesBucket).Delete([]byte(importPath))
+	})
+}
+
+// List returns the records of the packages under an import path prefix,
+// sorted by import path, as bolt keeps them. An empty prefix lists all of
+// them.
+func (meta *MetaStore) List(prefix string) ([]*PackageRecord, error) {
+	recs := []*PackageRecord{}
+	err := meta.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(packagesBucket).Cursor()
+		for k, v := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, v = c.Next() {
+			if !hasPathPrefix(string(k), prefix) {
+				continue
+			}
+			rec := new(PackageRecord)
+			err := json.Unmarshal(v, rec)
+			if err != nil {
+				return err
+			}
+			recs = append(recs, rec)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
@@ -0,0 +1,80 @@
+package docindex
+
+import (
+	"fmt"
+	"go/ast"
+	"go/doc"
+	"sort"
+	"strings"
+)
+
+// Note is a marked note, like "BUG(who): ...", found in the package
+// comments.
+type Note struct {
+	Marker string `json:"marker"`
+	UID    string `json:"uid"`
+	Body   string `json:"body"`
+	// Text is the note as it was written, which is the searchable part.
+	Text string `json:"text"`
+}
+
+// KnownBug reports whether the note is a "BUG(who)" note.
+func (n Note) KnownBug() bool {
+	return n.Marker == "BUG"
+}
+
+func newNote(marker string, n *doc.Note) Note {
+	body := strings.TrimSpace(n.Body)
+	return Note{
+		Marker: marker,
+		UID:    n.UID,
+		Body:   body,
+		Text:   fmt.Sprintf("%s(%s): %s", marker, n.UID, body),
+	}
+}
+
+// newNotes returns all the notes of a package, sorted by marker.
+func newNotes(pkgDoc *doc.Package) []Note {
+	markers := []string{}
+	for marker := range pkgDoc.Notes {
+		markers = append(markers, marker)
+	}
+	sort.Strings(markers)
+	notes := []Note{}
+	for _, marker := range markers {
+		for _, n := range pkgDoc.Notes[marker] {
+			notes = append(notes, newNote(marker, n))
+		}
+	}
+	return notes
+}
+
+// notesOf returns the notes written inside a declaration or in the comment
+// right before it.
+func (src *Source) notesOf(decl ast.Node) []Note {
+	if src == nil || decl == nil {
+		return nil
+	}
+	declFile := src.fset.File(decl.Pos())
+	if declFile == nil {
+		return nil
+	}
+	declLine := declFile.Line(decl.Pos())
+	notes := []Note{}
+	for marker, list := range src.notes {
+		for _, n := range list {
+			if src.fset.File(n.Pos) != declFile {
+				continue
+			}
+			inside := decl.Pos() <= n.Pos && n.Pos < decl.End()
+			before := n.End <= decl.Pos() && declLine-declFile.Line(n.End) <= 1
+			if inside || before {
+				notes = append(notes, newNote(marker, n))
+			}
+		}
+	}
+	sort.Slice(notes, func(i, j int) bool {
+		return notes[i].Text < notes[j].Text
+	})
+	return notes
+}
@@ -0,0 +1,176 @@
+package docindex
+
+import (
+	"errors"
+	"html/template"
+	"sort"
+
+	"github.com/blevesearch/bleve"
+)
+
+// ErrPackageNotFound is returned when a package is not in the index.
+var ErrPackageNotFound = errors.New("Package not found")
+
+// maxPageEntries is the maximum number of entries shown in a package page.
+const maxPageEntries = 1000
+
+// PackagePage is the documentation of a package as shown in its Ging page.
+type PackagePage struct {
+	Name        string
+	ImportPath  string
+	Kind        DocKind
+	Synopsis    string
+	Doc         template.HTML
+	Deprecation string
+	Link        string
+	SourceLink  string
+	Notes       []Note
+	Warnings    []string
+	Entries     []*PageEntry
+}
+
+// PageEntry is a function, method, constant, variable or type of a package
+// page.
+type PageEntry struct {
+	Anchor      string
+	Name        string
+	Kind        DocKind
+	Doc         template.HTML
+	TypeParams  string
+	Deprecation string
+	Link        string
+	SourceLink  string
+	Notes       []Note
+}
+
+// LoadPackagePage reads a package and all its entries from the index.
+func LoadPackagePage(index bleve.Index, importPath string) (*PackagePage, error) {
+	search := bleve.NewSearchRequest(bleve.NewDocIDQuery([]string{importPath}))
+	search.Fields = []string{"*"}
+	sr, err := index.Search(search)
+	if err != nil {
+		return nil, err
+	}
+	if len(sr.Hits) <= 0 {
+		return nil, ErrPackageNotFound
+	}
+	fields := sr.Hits[0].Fields
+	kind := DocKind(stringField(fields, "kind"))
+	page := &PackagePage{
+		Name:        stringField(fields, "name"),
+		ImportPath:  importPath,
+		Kind:        kind,
+		Synopsis:    stringField(fields, "synopsis"),
+		Doc:         template.HTML(stringField(fields, "html")),
+		Deprecation: stringField(fields, "deprecation"),
+		Link:        buildLink(kind, importPath, "", ""),
+		SourceLink:  sourceViewLinkField(fields, importPath),
+		Notes:       notesField(fields),
+		Warnings:    stringsField(fields, "warnings"),
+	}
+
+	search = bleve.NewSearchRequestOptions(
+		bleve.NewTermQuery(importPath).SetField("import"), maxPageEntries, 0, false)
+	search.Fields = []string{"*"}
+	sr, err = index.Search(search)
+	if err != nil {
+		return nil, err
+	}
+	for _, hit := range sr.Hits {
+		if hit.ID == importPath {
+			continue
+		}
+		page.Entries = append(page.Entries, newPageEntry(importPath, hit.Fields))
+	}
+	sort.Sort(pageEntriesByKind(page.Entries))
+	return page, nil
+}
+
+func newPageEntry(importPath string, fields map[string]interface{}) *PageEntry {
+	kind := DocKind(stringField(fields, "kind"))
+	name := stringField(fields, "name")
+	receiver := stringField(fields, "recv")
+	return &PageEntry{
+		Anchor:      pageAnchor(kind, name, receiver),
+		Name:        name,
+		Kind:        kind,
+		Doc:         template.HTML(stringField(fields, "html")),
+		Deprecation: stringField(fields, "deprecation"),
+		TypeParams: formatTypeParams(
+			stringsField(fields, "typeparams.name"),
+			stringsField(fields, "typeparams.constraint")),
+		Link:       buildLink(kind, importPath, name, receiver),
+		SourceLink: sourceViewLinkField(fields, importPath),
+		Notes:      notesField(fields),
+	}
+}
+
+// PageLink returns the link to the Ging page of a package, or to an entry
+// of it.
+func PageLink(kind DocKind, importPath, name, receiver string) string {
+	link := "/pkg/" + importPath
+	if anchor := pageAnchor(kind, name, receiver); len(anchor) > 0 {
+		link += "#" + anchor
+	}
+	return link
+}
+
+func pageAnchor(kind DocKind, name, receiver string) string {
+	switch kind {
+	case PackageKind, CommandKind:
+		return ""
+	case MethodKind:
+		if len(receiver) > 0 {
+			return receiver + "." + name
+		}
+	}
+	return name
+}
+
+// kindOrder is the order of the entries of a package page.
+var kindOrder = map[DocKind]int{
+	ConstKind:  0,
+	VarKind:    1,
+	FuncKind:   2,
+	TypeKind:   3,
+	MethodKind: 3,
+}
+
+// pageEntriesByKind sorts entries like godoc does: constants, variables,
+// functions and types, with methods right after their type.
+type pageEntriesByKind []*PageEntry
+
+func (es pageEntriesByKind) Len() int      { return len(es) }
+func (es pageEntriesByKind) Swap(i, j int) { es[i], es[j] = es[j], es[i] }
+func (es pageEntriesByKind) Less(i, j int) bool {
+	if kindOrder[es[i].Kind] != kindOrder[es[j].Kind] {
+		return kindOrder[es[i].Kind] < kindOrder[es[j].Kind]
+	}
+	return es[i].Anchor < es[j].Anchor
+}
+
+func stringField(fields map[string]interface{}, name string) string {
+	s, _ := fields[name].(string)
+	return s
+}
+
+func sourceViewLinkField(fields map[string]interface{}, importPath string) string {
+	line, _ := fields["pos.line"].(float64)
+	return SourceViewLink(importPath, stringField(fields, "pos.file"), int(line))
+}
+
+// notesField rebuilds the stored notes of an entry.
+func notesField(fields map[string]interface{}) []Note {
+	markers := stringsField(fields, "notes.marker")
+	uids := stringsField(fields, "notes.uid")
+	bodies := stringsField(fields, "notes.body")
+	texts := stringsField(fields, "notes.text")
+	if len(markers) != len(uids) || len(markers) != len(bodies) || len(markers) != len(texts) {
+		return nil
+	}
+	notes := make([]Note, len(markers))
+	for i := range markers {
+		notes[i] = Note{Marker: markers[i], UID: uids[i], Body: bodies[i], Text: texts[i]}
+	}
+	return notes
+}
@@ -0,0 +1,116 @@
+package docindex
+
+import (
+	"sort"
+	"strings"
+)
+
+// Platforms lists the platforms, as "goos/goarch", an entry is available on.
+type Platforms struct {
+	All    []string `json:"all"`
+	GOOS   []string `json:"goos"`
+	GOARCH []string `json:"goarch"`
+}
+
+func (ps *Platforms) add(platforms []string) {
+	for _, p := range platforms {
+		ps.All = appendUnique(ps.All, p)
+		parts := strings.SplitN(p, "/", 2)
+		if len(parts) == 2 {
+			ps.GOOS = appendUnique(ps.GOOS, parts[0])
+			ps.GOARCH = appendUnique(ps.GOARCH, parts[1])
+		}
+	}
+	sort.Strings(ps.All)
+	sort.Strings(ps.GOOS)
+	sort.Strings(ps.GOARCH)
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, e := range list {
+		if e == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// platformEntry is an indexed entry of a package.
+type platformEntry interface {
+	ID() string
+	addPlatforms(platforms []string)
+}
+
+func (fn *Func) addPlatforms(platforms []string) { fn.Platforms.add(platforms) }
+func (v *Value) addPlatforms(platforms []string) { v.Platforms.add(platforms) }
+func (t *Type) addPlatforms(platforms []string)  { t.Platforms.add(platforms) }
+
+func (pkg *Package) entries() []platformEntry {
+	es := []platformEntry{}
+	for _, fn := range pkg.Funcs {
+		es = append(es, fn)
+	}
+	for _, c := range pkg.Consts {
+		es = append(es, c)
+	}
+	for _, v := range pkg.Vars {
+		es = append(es, v)
+	}
+	for _, t := range pkg.Types {
+		es = append(es, t)
+	}
+	return es
+}
+
+// setPlatforms marks the package and all its entries as available on the
+// given platforms.
+func (pkg *Package) setPlatforms(platforms []string) {
+	pkg.Platforms.add(platforms)
+	for _, e := range pkg.entries() {
+		e.addPlatforms(platforms)
+	}
+}
+
+// merge adds the entries of the same package built for other platforms.
+// Entries found in both get the platforms of other, and the rest are added
+// as they are.
+func (pkg *Package) merge(other *Package, platforms []string) {
+	pkg.Platforms.add(platforms)
+	for _, n := range other.Notes {
+		if !hasNote(pkg.Notes, n) {
+			pkg.Notes = append(pkg.Notes, n)
+		}
+	}
+	known := map[string]platformEntry{}
+	for _, e := range pkg.entries() {
+		known[e.ID()] = e
+	}
+	for _, e := range other.entries() {
+		if k, ok := known[e.ID()]; ok {
+			k.addPlatforms(platforms)
+			continue
+		}
+		e.addPlatforms(platforms)
+		switch e := e.(type) {
+		case *Func:
+			pkg.Funcs = append(pkg.Funcs, e)
+		case *Value:
+			if e.Kind == ConstKind {
+				pkg.Consts = append(pkg.Consts, e)
+			} else {
+				pkg.Vars = append(pkg.Vars, e)
+			}
+		case *Type:
+			pkg.Types = append(pkg.Types, e)
+		}
+	}
+}
+
+func hasNote(notes []Note, note Note) bool {
+	for _, n := range notes {
+		if n == note {
+			return true
+		}
+	}
+	return false
+}
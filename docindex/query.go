@@ -0,0 +1,141 @@
+package docindex
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+)
+
+// notDeprecatedBoost is the boost given to entries which are not deprecated,
+// so deprecated APIs rank after their replacements.
+const notDeprecatedBoost = 0.5
+
+// queryFilter is a "key:value" term of a query string. Negated filters are
+// written with a leading dash, as in "-deprecated".
+type queryFilter struct {
+	key    string
+	value  string
+	negate bool
+}
+
+// parsedQuery is a query string split in its free text and its filters.
+type parsedQuery struct {
+	text    string
+	filters []queryFilter
+}
+
+// filterQueries builds the query of each supported filter from its value.
+// Filters written without a value, as in "-deprecated", get "true".
+var filterQueries = map[string]func(value string) bleve.Query{
+	"deprecated": func(value string) bleve.Query {
+		return bleve.NewBoolFieldQuery(value != "false").SetField("deprecated")
+	},
+	"goos": func(value string) bleve.Query {
+		return bleve.NewTermQuery(value).SetField("platforms.goos")
+	},
+	"goarch": func(value string) bleve.Query {
+		return bleve.NewTermQuery(value).SetField("platforms.goarch")
+	},
+	"generic": func(value string) bleve.Query {
+		return bleve.NewBoolFieldQuery(value != "false").SetField("generic")
+	},
+	"typeparam": func(value string) bleve.Query {
+		return bleve.NewDisjunctionQuery([]bleve.Query{
+			bleve.NewTermQuery(value).SetField("constraints"),
+			bleve.NewTermQuery(value).SetField("typeparams.name"),
+		})
+	},
+	"in": func(value string) bleve.Query {
+		// Either the whole import path or its last elements
+		return bleve.NewRegexpQuery("(.*/)?" + regexp.QuoteMeta(value)).SetField("import")
+	},
+	"host": func(value string) bleve.Query {
+		return bleve.NewTermQuery(value).SetField("host")
+	},
+	"std": func(value string) bleve.Query {
+		// Standard library import paths have no dot in their first element
+		if value == "false" {
+			return bleve.NewRegexpQuery(`.*\..*`).SetField("host")
+		}
+		return bleve.NewRegexpQuery(`[^.]+`).SetField("host")
+	},
+	"kind": func(value string) bleve.Query {
+		// Either the kind itself or the name of its document type
+		for kind, km := range kindMappings {
+			if km.docType == value {
+				value = string(kind)
+			}
+		}
+		return bleve.NewTermQuery(value).SetField("kind")
+	},
+}
+
+// parseQuery extracts the supported filters of a query string. Anything else
+// is kept as free text.
+func parseQuery(queryString string) parsedQuery {
+	pq := parsedQuery{}
+	words := []string{}
+	for _, word := range strings.Fields(queryString) {
+		filter, ok := parseFilter(word)
+		if ok {
+			pq.filters = append(pq.filters, filter)
+		} else {
+			words = append(words, word)
+		}
+	}
+	pq.text = strings.Join(words, " ")
+	return pq
+}
+
+func parseFilter(word string) (queryFilter, bool) {
+	filter := queryFilter{}
+	if strings.HasPrefix(word, "-") {
+		filter.negate = true
+		word = word[1:]
+	}
+	filter.key, filter.value = word, "true"
+	if i := strings.Index(word, ":"); i >= 0 {
+		filter.key, filter.value = word[:i], word[i+1:]
+		if len(filter.value) <= 0 {
+			return filter, false
+		}
+	} else if !filter.negate {
+		// Bare words are only filters when negated
+		return filter, false
+	}
+	_, ok := filterQueries[filter.key]
+	return filter, ok
+}
+
+// build combines a query for the free text of a parsed query with its
+// filters and Ging's own ranking adjustments.
+func (pq parsedQuery) build(textQuery bleve.Query) bleve.Query {
+	if len(pq.text) <= 0 && len(pq.filters) > 0 {
+		textQuery = bleve.NewMatchAllQuery()
+	}
+	must := []bleve.Query{textQuery}
+	mustNot := []bleve.Query{}
+	for _, filter := range pq.filters {
+		q := filterQueries[filter.key](filter.value)
+		if filter.negate {
+			mustNot = append(mustNot, q)
+		} else {
+			must = append(must, q)
+		}
+	}
+	should := []bleve.Query{
+		bleve.NewBoolFieldQuery(false).SetField("deprecated").SetBoost(notDeprecatedBoost),
+	}
+	return bleve.NewBooleanQuery(must, should, mustNot)
+}
+
+// hasFilter reports whether the query has a given (not negated) filter.
+func (pq parsedQuery) hasFilter(key, value string) bool {
+	for _, filter := range pq.filters {
+		if !filter.negate && filter.key == key && filter.value == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package docindex
+
+import (
+	"log"
+
+	"github.com/blevesearch/bleve"
+)
+
+// RebuildIndex creates a new index at indexPath, with the current mapping,
+// and indexes there every recorded package from its stored sources. Nothing
+// is fetched, so packages are indexed as they were last fetched, and no
+// record is written, so it can run while packages are being fetched.
+func RebuildIndex(indexPath string, sources *SourceStore, meta *MetaStore) (bleve.Index, error) {
+	index, err := newIndex(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	recs, err := meta.List("")
+	if err != nil {
+		index.Close()
+		return nil, err
+	}
+	// Packages linked by packages indexed after them are indexed again at
+	// the end, with all the doc links to them in the index
+	stale := []string{}
+	seen := map[string]bool{}
+	for _, rec := range recs {
+		// Packages are in the index as long as they were ever fetched, even
+		// if their last attempt failed
+		if rec.FetchedAt.IsZero() {
+			continue
+		}
+		pkgStale, err := reindexStoredPackage(index, sources, meta, rec.ImportPath)
+		if err != nil {
+			// A package which can not be read now is not worth the whole index
+			log.Printf("Error reindexing package %s: %s.\n", rec.ImportPath, err.Error())
+		}
+		for _, p := range pkgStale {
+			if !seen[p] {
+				seen[p] = true
+				stale = append(stale, p)
+			}
+		}
+	}
+	reindexStale(index, sources, meta, stale)
+	return index, nil
+}
+
+// ReindexStoredPackage indexes a package from its stored sources. Its record
+// is only read, so it can be called while packages are being fetched.
+func ReindexStoredPackage(index bleve.Index, sources *SourceStore, meta *MetaStore, importPath string) error {
+	stale, err := reindexStoredPackage(index, sources, meta, importPath)
+	if err != nil {
+		return err
+	}
+	reindexStale(index, sources, meta, stale)
+	return nil
+}
+
+// reindexStale indexes again the packages whose promotion by inbound doc
+// links is outdated. Their doc links do not change, so no other package gets
+// outdated by them.
+func reindexStale(index bleve.Index, sources *SourceStore, meta *MetaStore, stale []string) {
+	for _, importPath := range stale {
+		_, err := reindexStoredPackage(index, sources, meta, importPath)
+		if err != nil {
+			log.Printf("Error reindexing package %s: %s.\n", importPath, err.Error())
+		}
+	}
+}
+
+func reindexStoredPackage(index bleve.Index, sources *SourceStore, meta *MetaStore, importPath string) ([]string, error) {
+	rec, err := meta.Get(importPath)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := sources.Directory(importPath)
+	if err != nil {
+		return nil, err
+	}
+	dir.Etag = rec.Etag
+	dir.VCS = rec.VCS
+	dir.BrowseURL = rec.BrowseURL
+	fetched, err := readPackage(dir)
+	if err != nil {
+		return nil, err
+	}
+	// indexFetched updates the record, but it is the fetches which keep it
+	return indexFetched(index, fetched, rec, rec.FetchedAt)
+}
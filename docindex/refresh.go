@@ -0,0 +1,46 @@
+package docindex
+
+import (
+	"hash/fnv"
+	"time"
+)
+
+const (
+	// refreshJitter is the fraction of the refresh interval packages are
+	// spread over, so packages indexed together are not refreshed together.
+	refreshJitter = 0.2
+	// maxRefreshBackoff is the maximum number of times the refresh interval
+	// is doubled for packages failing to be indexed.
+	maxRefreshBackoff = 5
+)
+
+// NextRefresh returns when a package is due to be checked for changes, given
+// the interval between checks. Failing packages back off exponentially.
+func (rec *PackageRecord) NextRefresh(interval time.Duration) time.Time {
+	backoff := rec.Failures
+	if backoff > maxRefreshBackoff {
+		backoff = maxRefreshBackoff
+	}
+	delay := interval << uint(backoff)
+	// The jitter depends on the package only, so it is stable between checks
+	h := fnv.New32a()
+	h.Write([]byte(rec.ImportPath))
+	jitter := time.Duration(float64(interval) * refreshJitter * float64(h.Sum32()%1000) / 1000)
+	return rec.CheckedAt.Add(delay + jitter)
+}
+
+// DuePackages returns the import paths of the packages due to be checked for
+// changes at a given time.
+func (meta *MetaStore) DuePackages(interval time.Duration, now time.Time) ([]string, error) {
+	recs, err := meta.List("")
+	if err != nil {
+		return nil, err
+	}
+	due := []string{}
+	for _, rec := range recs {
+		if !rec.NextRefresh(interval).After(now) {
+			due = append(due, rec.ImportPath)
+		}
+	}
+	return due, nil
+}
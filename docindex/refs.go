@@ -0,0 +1,232 @@
+package docindex
+
+import (
+	"go/doc/comment"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+)
+
+// DocRef is a doc link, like [io.Reader], of a doc comment.
+type DocRef struct {
+	// Target is the document identifier of the referenced package or symbol.
+	Target string `json:"target"`
+	// Text is the link as it was written, without brackets.
+	Text string `json:"text"`
+	// Link is the Ging page of the referenced package or symbol.
+	Link string `json:"link"`
+}
+
+// docRefs returns the doc links and the URLs found in a doc comment.
+func (r *docRenderer) docRefs(d *comment.Doc) ([]DocRef, []string) {
+	refs := []DocRef{}
+	urls := []string{}
+	seen := map[string]bool{}
+	var visitText func(ts []comment.Text)
+	visitText = func(ts []comment.Text) {
+		for _, t := range ts {
+			switch t := t.(type) {
+			case *comment.DocLink:
+				ref := r.docRef(t)
+				if !seen[ref.Target] {
+					seen[ref.Target] = true
+					refs = append(refs, ref)
+				}
+			case *comment.Link:
+				urls = appendUnique(urls, t.URL)
+			}
+		}
+	}
+	var visitBlocks func(bs []comment.Block)
+	visitBlocks = func(bs []comment.Block) {
+		for _, b := range bs {
+			switch b := b.(type) {
+			case *comment.Paragraph:
+				visitText(b.Text)
+			case *comment.Heading:
+				visitText(b.Text)
+			case *comment.List:
+				for _, item := range b.Items {
+					visitBlocks(item.Content)
+				}
+			}
+		}
+	}
+	visitBlocks(d.Content)
+	return refs, urls
+}
+
+func (r *docRenderer) docRef(link *comment.DocLink) DocRef {
+	target := r.linkImportPath(link)
+	if len(link.Recv) > 0 {
+		target += "." + link.Recv
+	}
+	if len(link.Name) > 0 {
+		target += "." + link.Name
+	}
+	return DocRef{
+		Target: target,
+		Text:   plainText(link.Text),
+		Link:   r.docLinkURL(link),
+	}
+}
+
+// plainText returns the text of a doc comment span without its markup.
+func plainText(ts []comment.Text) string {
+	parts := []string{}
+	for _, t := range ts {
+		switch t := t.(type) {
+		case comment.Plain:
+			parts = append(parts, string(t))
+		case comment.Italic:
+			parts = append(parts, string(t))
+		case *comment.Link:
+			parts = append(parts, plainText(t.Text))
+		case *comment.DocLink:
+			parts = append(parts, plainText(t.Text))
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+/*
+Inbound references
+*/
+
+// inboundRefsWeight is how much documents referenced by other doc comments
+// are promoted. Their scores are multiplied by one plus the weight times the
+// logarithm of the number of references.
+const inboundRefsWeight = 0.2
+
+// maxInboundTier is the highest promotion tier. Documents are indexed with
+// the tier of their number of inbound doc links, which is its bit length, so
+// results are promoted from a stored field and tiers seldom change.
+const maxInboundTier = 12
+
+// inboundTier returns the promotion tier of a document with n inbound doc
+// links.
+func inboundTier(n uint64) string {
+	t := bits.Len64(n)
+	if t > maxInboundTier {
+		t = maxInboundTier
+	}
+	return strconv.Itoa(t)
+}
+
+// inboundFactor returns the factor the scores of the documents of a tier are
+// multiplied by, the one of the fewest doc links of the tier.
+func inboundFactor(tier string) float64 {
+	t, err := strconv.Atoi(tier)
+	if err != nil || t <= 0 {
+		return 1
+	}
+	return 1 + inboundRefsWeight*math.Log1p(float64(uint64(1)<<uint(t-1)))
+}
+
+// inboundCounts returns the number of doc links to the documents whose
+// identifier starts with prefix.
+func inboundCounts(index bleve.Index, prefix string) (map[string]uint64, error) {
+	dict, err := index.FieldDictPrefix("refs.target", []byte(prefix))
+	if err != nil {
+		return nil, err
+	}
+	defer dict.Close()
+	counts := map[string]uint64{}
+	entry, err := dict.Next()
+	for err == nil && entry != nil {
+		counts[entry.Term] = entry.Count
+		entry, err = dict.Next()
+	}
+	return counts, err
+}
+
+// setInbound sets the promotion tier of a package and its entries.
+func (pkg *Package) setInbound(counts map[string]uint64) {
+	pkg.Inbound = inboundTier(counts[pkg.ImportPath])
+	for _, fn := range pkg.Funcs {
+		fn.Inbound = inboundTier(counts[fn.ID()])
+	}
+	for _, v := range pkg.Consts {
+		v.Inbound = inboundTier(counts[v.ID()])
+	}
+	for _, v := range pkg.Vars {
+		v.Inbound = inboundTier(counts[v.ID()])
+	}
+	for _, t := range pkg.Types {
+		t.Inbound = inboundTier(counts[t.ID()])
+	}
+}
+
+// outboundTargets returns the targets of the doc links of a package.
+func (pkg *Package) outboundTargets() []string {
+	targets := []string{}
+	add := func(refs []DocRef) {
+		for _, ref := range refs {
+			targets = append(targets, ref.Target)
+		}
+	}
+	add(pkg.Refs)
+	for _, fn := range pkg.Funcs {
+		add(fn.Refs)
+	}
+	for _, v := range pkg.Consts {
+		add(v.Refs)
+	}
+	for _, v := range pkg.Vars {
+		add(v.Refs)
+	}
+	for _, t := range pkg.Types {
+		add(t.Refs)
+	}
+	return targets
+}
+
+// staleInbound returns the packages, other than importPath, with documents
+// among targets indexed with an outdated promotion tier.
+func staleInbound(index bleve.Index, importPath string, targets []string) ([]string, error) {
+	if len(targets) <= 0 {
+		return nil, nil
+	}
+	search := bleve.NewSearchRequestOptions(bleve.NewDocIDQuery(targets), len(targets), 0, false)
+	search.Fields = []string{"import", "inbound"}
+	sr, err := index.Search(search)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{importPath: true}
+	stale := []string{}
+	for _, hit := range sr.Hits {
+		pkgPath, _ := hit.Fields["import"].(string)
+		tier, _ := hit.Fields["inbound"].(string)
+		if seen[pkgPath] {
+			continue
+		}
+		counts, err := inboundCounts(index, hit.ID)
+		if err != nil {
+			return nil, err
+		}
+		if inboundTier(counts[hit.ID]) != tier {
+			seen[pkgPath] = true
+			stale = append(stale, pkgPath)
+		}
+	}
+	return stale, nil
+}
+
+// docRefsField rebuilds the stored doc links of a document.
+func docRefsField(fields map[string]interface{}) []DocRef {
+	targets := stringsField(fields, "refs.target")
+	texts := stringsField(fields, "refs.text")
+	links := stringsField(fields, "refs.link")
+	if len(targets) != len(texts) || len(targets) != len(links) {
+		return nil
+	}
+	refs := make([]DocRef, len(targets))
+	for i := range targets {
+		refs[i] = DocRef{Target: targets[i], Text: texts[i], Link: links[i]}
+	}
+	return refs
+}
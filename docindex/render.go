@@ -0,0 +1,125 @@
+package docindex
+
+import (
+	"go/doc"
+	"go/doc/comment"
+	"strings"
+)
+
+// docRenderer renders the doc comments of a package, resolving doc links like
+// [io.Reader] to Ging pages.
+type docRenderer struct {
+	importPath string
+	parser     *comment.Parser
+	printer    *comment.Printer
+}
+
+// renderedDoc is a doc comment as plain text, which is what gets indexed, and
+// as HTML, which is what gets shown.
+type renderedDoc struct {
+	text string
+	html string
+	// refs and urls are the doc links and the URLs of the comment.
+	refs []DocRef
+	urls []string
+}
+
+func newDocRenderer(pkgDoc *doc.Package) *docRenderer {
+	r := &docRenderer{
+		importPath: pkgDoc.ImportPath,
+		parser:     pkgDoc.Parser(),
+		printer:    pkgDoc.Printer(),
+	}
+	r.printer.DocLinkURL = r.docLinkURL
+	return r
+}
+
+// docLinkURL returns the Ging page of the target of a doc link.
+func (r *docRenderer) docLinkURL(link *comment.DocLink) string {
+	return PageLink(docLinkKind(link), r.linkImportPath(link), link.Name, link.Recv)
+}
+
+// linkImportPath returns the import path of the target of a doc link, which
+// is the package being read when the link does not name one.
+func (r *docRenderer) linkImportPath(link *comment.DocLink) string {
+	if len(link.ImportPath) <= 0 && r != nil {
+		return r.importPath
+	}
+	return link.ImportPath
+}
+
+// docLinkKind guesses the kind of the target of a doc link. It only has to
+// be precise enough to build the anchor of the target in its page.
+func docLinkKind(link *comment.DocLink) DocKind {
+	switch {
+	case len(link.Recv) > 0:
+		return MethodKind
+	case len(link.Name) > 0:
+		return FuncKind
+	}
+	return PackageKind
+}
+
+// render renders a doc comment. A nil renderer renders it without resolving
+// doc links.
+func (r *docRenderer) render(text string) renderedDoc {
+	d := r.parse(text)
+	rendered := renderedDoc{
+		text: r.text(d),
+		html: string(r.getPrinter().HTML(d)),
+	}
+	rendered.refs, rendered.urls = r.docRefs(d)
+	return rendered
+}
+
+// renderProse renders a doc comment without its code blocks, which are
+// returned apart.
+func (r *docRenderer) renderProse(text string) (renderedDoc, []string) {
+	d := r.parse(text)
+	code := []string{}
+	prose := &comment.Doc{Links: d.Links}
+	for _, block := range d.Content {
+		if c, ok := block.(*comment.Code); ok {
+			code = append(code, c.Text)
+			continue
+		}
+		prose.Content = append(prose.Content, block)
+	}
+	rendered := renderedDoc{
+		text: r.text(prose),
+		html: string(r.getPrinter().HTML(d)),
+	}
+	rendered.refs, rendered.urls = r.docRefs(d)
+	return rendered, code
+}
+
+func (r *docRenderer) parse(text string) *comment.Doc {
+	if r == nil {
+		return new(comment.Parser).Parse(text)
+	}
+	return r.parser.Parse(text)
+}
+
+func (r *docRenderer) text(d *comment.Doc) string {
+	// Link definitions are only noise for the index
+	p := *r.getPrinter()
+	p.TextWidth = -1
+	d = &comment.Doc{Content: d.Content}
+	return strings.TrimSpace(string(p.Text(d)))
+}
+
+func (r *docRenderer) getPrinter() *comment.Printer {
+	if r == nil {
+		return &comment.Printer{DocLinkURL: r.docLinkURL}
+	}
+	return r.printer
+}
+
+// renderDoc renders a doc comment of the package being read.
+func (src *Source) renderDoc(text string) renderedDoc {
+	var docs *docRenderer
+	if src != nil {
+		docs = src.docs
+	}
+	return docs.render(text)
+}
@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"html/template"
 	"log"
+	"net/url"
 	"path"
+	"sort"
+	"time"
 
 	"github.com/blevesearch/bleve"
 	"github.com/blevesearch/bleve/search"
@@ -17,8 +20,87 @@ type SearchResult struct {
 	Name       string
 	Type       DocKind
 	Link       string
+	PageLink   string
+	SourceLink string
+	Synopsis   string
 	Match      string
+
+	Deprecated  bool
+	Deprecation string
+
+	// Platforms lists the platforms the entry is available on, and it is
+	// only set when they are not all the indexed ones.
+	Platforms []string
+
+	// TypeParams is the type parameter list of generic functions and types.
+	TypeParams string
+
+	// KnownBugs are the BUG notes of the entry.
+	KnownBugs []string
+
+	// ImportPath is the import path of the package of the entry, and
+	// Indexed when it was indexed.
+	ImportPath string
+	Indexed    time.Time
+
+	// Refs and URLs are the doc links and the URLs of the entry doc.
+	Refs []DocRef
+	URLs []string
+
 	Highlights SearchHighlights
+
+	// Score is the relevance of the result: the score of its match,
+	// multiplied by its promotions.
+	Score float64
+
+	// Explanation is how the score of the result was computed. It is only
+	// set when the search is explained.
+	Explanation *Explanation
+}
+
+// SearchOptions are the options of a search besides the query.
+type SearchOptions struct {
+	// Sort is the order of the results, by relevance if empty.
+	Sort string
+	// Host only searches the packages hosted there, and Std only those of
+	// the standard library, like the host: and std: filters.
+	Host string
+	Std  bool
+	// Explain explains the score of every result.
+	Explain bool
+	// Suggest corrects the spelling of queries without results. It scans the
+	// index vocabulary, so it is not meant for searches made while typing.
+	Suggest bool
+}
+
+// filters returns the query filters the options stand for.
+func (opts SearchOptions) filters() []queryFilter {
+	filters := []queryFilter{}
+	if len(opts.Host) > 0 {
+		filters = append(filters, queryFilter{key: "host", value: opts.Host})
+	}
+	if opts.Std {
+		filters = append(filters, queryFilter{key: "std", value: "true"})
+	}
+	return filters
+}
+
+// Link returns the link to the results of a query searched with the options.
+func (opts SearchOptions) Link(queryString string) string {
+	values := url.Values{"query": {queryString}}
+	if len(opts.Sort) > 0 && opts.Sort != SortRelevance {
+		values.Set("sort", opts.Sort)
+	}
+	if len(opts.Host) > 0 {
+		values.Set("host", opts.Host)
+	}
+	if opts.Std {
+		values.Set("std", "1")
+	}
+	if opts.Explain {
+		values.Set("explain", "1")
+	}
+	return "/query?" + values.Encode()
 }
 
 // SearchHighlights ...
@@ -26,38 +108,124 @@ type SearchResult struct {
 type SearchHighlights struct {
 	Name    template.HTML
 	Content template.HTML
+	// ContentField is the field the content highlight comes from.
+	ContentField string
+	// Code is the matching code snippet of the documentation, if any.
+	Code template.HTML
 }
 
-// Search ...
-// TODO(alvivi): doc this
-func Search(index bleve.Index, queryString string) ([]*SearchResult, *bleve.SearchResult, error) {
-	matchQuery := bleve.NewMatchPhraseQuery(queryString)
-	entries, sr, err := performSearch(index, matchQuery)
-	if err != nil {
-		return nil, nil, err
+// synopsisBoost is the boost of matches in package synopses over matches
+// anywhere else.
+const synopsisBoost = 2.0
+
+// SearchResults are the results of a query and their facets.
+type SearchResults struct {
+	// Query is the query the results are for, which is the suggested one
+	// when the suggestion was applied.
+	Query   string
+	Results []*SearchResult
+	Facets  []*Facet
+	Total   uint64
+	Took    time.Duration
+
+	// SortedTop is how many of the best matches were sorted, when results
+	// are sorted other than by relevance and there are more matches.
+	SortedTop int
+
+	// Suggestion is a spelling correction of a query without results.
+	Suggestion *Suggestion
+}
+
+// Search searches the index. Queries without results get a spelling
+// correction when opts.Suggest is set, whose results are returned instead
+// when it is confident.
+// Results are ranked among the best matches, up to rankWindow of them, and
+// the first page of them is returned. Explained searches are slower, so they
+// are meant for debugging rankings.
+func Search(index bleve.Index, queryString string, opts SearchOptions) (*SearchResults, error) {
+	results, err := searchQuery(index, queryString, opts)
+	if err != nil || results.Total > 0 || !opts.Suggest {
+		return results, err
+	}
+	suggestion, err := suggest(index, queryString)
+	if err != nil || suggestion == nil {
+		return results, err
 	}
-	if sr.Total > 0 {
-		return entries, sr, nil
+	suggestion.Link = opts.Link(suggestion.Query)
+	results.Suggestion = suggestion
+	if !suggestion.Confident {
+		return results, nil
 	}
-	fuzzyQuery := bleve.NewMatchQuery(queryString)
-	fuzzyQuery.SetFuzziness(2)
-	entries, fsr, err := performSearch(index, fuzzyQuery)
+	suggested, err := searchQuery(index, suggestion.Query, opts)
+	if err != nil || suggested.Total <= 0 {
+		return results, err
+	}
+	suggestion.Applied = true
+	suggested.Suggestion = suggestion
+	return suggested, nil
+}
+
+func searchQuery(index bleve.Index, queryString string, opts SearchOptions) (*SearchResults, error) {
+	if !ValidSortOrder(opts.Sort) {
+		return nil, fmt.Errorf("Unknown sort order %q", opts.Sort)
+	}
+	pq := parseQuery(queryString)
+	pq.filters = append(pq.filters, opts.filters()...)
+	matchQuery := bleve.NewDisjunctionQuery([]bleve.Query{
+		bleve.NewMatchPhraseQuery(pq.text),
+		bleve.NewMatchPhraseQuery(pq.text).SetField("synopsis").SetBoost(synopsisBoost),
+		bleve.NewMatchPhraseQuery(pq.text).SetField("code"),
+	})
+	entries, sr, err := performSearch(index, pq.build(matchQuery), opts)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	fsr.Merge(sr)
-	return entries, fsr, nil
+	results := &SearchResults{
+		Query:   queryString,
+		Results: entries,
+		Facets:  newFacets(queryString, pq, opts, sr),
+		Total:   sr.Total,
+		Took:    sr.Took,
+	}
+	// bleve only returns matches by score, so other orders only sort the
+	// best ones
+	if len(opts.Sort) > 0 && opts.Sort != SortRelevance && sr.Total > rankWindow {
+		results.SortedTop = rankWindow
+	}
+	return results, nil
 }
 
-func performSearch(index bleve.Index, query bleve.Query) ([]*SearchResult, *bleve.SearchResult, error) {
+func performSearch(index bleve.Index, query bleve.Query, opts SearchOptions) ([]*SearchResult, *bleve.SearchResult, error) {
 	search := bleve.NewSearchRequest(query)
 	search.Fields = []string{
 		"name",
 		"kind",
 		"import",
+		"synopsis",
+		"deprecated",
+		"deprecation",
+		"platforms.all",
+		"typeparams.name",
+		"typeparams.constraint",
+		"notes.marker",
+		"notes.text",
+		"refs.target",
+		"refs.text",
+		"refs.link",
+		"urls",
+		"recv",
+		"pos.file",
+		"pos.line",
+		"indexed",
+		"inbound",
 	}
 	search.Highlight = bleve.NewHighlightWithStyle("html")
-	search.Explain = false
+	// Results are promoted after the search, so the best matches are ranked
+	// and a page of them is returned
+	pageSize := search.Size
+	search.Size = rankWindow
+	addFacets(search)
+	search.Explain = opts.Explain
 	sr, err := index.Search(search) // sr, err := ...
 	if err != nil {
 		return []*SearchResult{}, nil, err
@@ -66,15 +234,32 @@ func performSearch(index bleve.Index, query bleve.Query) ([]*SearchResult, *blev
 	entries := []*SearchResult{}
 	for _, hit := range sr.Hits {
 		entry, err := newSearchResult(hit.Fields, hit.Fragments)
-		if err == nil {
-			entries = append(entries, entry)
-		} else {
+		if err != nil {
 			log.Printf("Error building a search result entry: %s.\n", err.Error())
+			continue
 		}
+		tier, _ := hit.Fields["inbound"].(string)
+		entry.Score = hit.Score * resultBoost(entry.Type, tier)
+		if hit.Expl != nil {
+			entry.Explanation = explainScore(hit.Expl, entry.Type, tier)
+		}
+		entries = append(entries, entry)
+	}
+	sort.Stable(byScore(entries))
+	sortResults(entries, opts.Sort)
+	if len(entries) > pageSize {
+		entries = entries[:pageSize]
 	}
 	return entries, sr, nil
 }
 
+// resultBoost returns the factor the score of a document is multiplied by
+// after the search, which promotes its kind and its inbound doc links.
+// Completions are ranked by it too.
+func resultBoost(kind DocKind, tier string) float64 {
+	return kindMappings[kind].boost * inboundFactor(tier)
+}
+
 func newSearchResult(fields map[string]interface{}, fragments search.FieldFragmentMap) (*SearchResult, error) {
 	// Name
 	nameValue, ok := fields["name"]
@@ -94,33 +279,124 @@ func newSearchResult(fields map[string]interface{}, fragments search.FieldFragme
 		return nil, errors.New("Required field 'import' not found")
 	}
 	importPath := importPathValue.(string)
-	// Link
-	var link string
-	switch doctype {
-	case PackageKind:
-		link = "http://" + path.Join("godoc.org/", importPath)
-	case FuncKind, MethodKind, ConstKind, VarKind, TypeKind:
-		basepath := "http://" + path.Join("godoc.org/", importPath)
-		link = fmt.Sprintf("%s#%s", basepath, name)
+	// Receiver (methods only)
+	var receiver string
+	if recvValue, ok := fields["recv"]; ok {
+		receiver, _ = recvValue.(string)
 	}
+	// Link
+	link := buildLink(doctype, importPath, name, receiver)
+	// Source
+	sourceLink := sourceViewLinkField(fields, importPath)
 	// Highlights - Name
-	var highlightName string
+	var highlightName template.HTML
 	if hname, ok := fragments["name"]; ok {
-		highlightName = hname[0]
+		highlightName = highlightFragment(hname[0])
+	}
+	// Synopsis (packages only)
+	var synopsis string
+	if synopsisValue, ok := fields["synopsis"]; ok {
+		synopsis, _ = synopsisValue.(string)
+	}
+	// Deprecation
+	deprecated, _ := fields["deprecated"].(bool)
+	deprecation, _ := fields["deprecation"].(string)
+	// Platforms
+	platforms := stringsField(fields, "platforms.all")
+	if len(platforms) >= len(buildEnvs) {
+		platforms = nil
+	}
+	// Type parameters
+	typeParams := formatTypeParams(
+		stringsField(fields, "typeparams.name"),
+		stringsField(fields, "typeparams.constraint"))
+	// Indexing time
+	var indexed time.Time
+	if indexedValue, ok := fields["indexed"].(string); ok {
+		indexed, _ = time.Parse(time.RFC3339, indexedValue)
+	}
+	// Known bugs
+	var knownBugs []string
+	noteMarkers := stringsField(fields, "notes.marker")
+	noteTexts := stringsField(fields, "notes.text")
+	if len(noteMarkers) == len(noteTexts) {
+		for i, marker := range noteMarkers {
+			if marker == "BUG" {
+				knownBugs = append(knownBugs, noteTexts[i])
+			}
+		}
 	}
 	// Highlights - Content
-	var highlightContent string
+	var highlightContent template.HTML
+	var highlightField string
 	if hcontent, ok := fragments["doc"]; ok {
-		highlightContent = hcontent[0]
+		highlightContent = highlightFragment(hcontent[0])
+		highlightField = "doc"
+	} else if hsynopsis, ok := fragments["synopsis"]; ok && (doctype == PackageKind || doctype == CommandKind) {
+		highlightContent = highlightFragment(hsynopsis[0])
+		highlightField = "synopsis"
+	}
+	// Highlights - Code
+	var highlightCode template.HTML
+	if hcode, ok := fragments["code"]; ok {
+		highlightCode = highlightFragment(hcode[0])
 	}
 
 	return &SearchResult{
-		Name: name,
-		Type: DocKind(doctype),
-		Link: link,
+		Name:       name,
+		Type:       DocKind(doctype),
+		Link:       link,
+		PageLink:   PageLink(doctype, importPath, name, receiver),
+		SourceLink: sourceLink,
+		Synopsis:   synopsis,
+		ImportPath: importPath,
+		Indexed:    indexed,
+
+		Deprecated:  deprecated,
+		Deprecation: deprecation,
+		Platforms:   platforms,
+		TypeParams:  typeParams,
+		KnownBugs:   knownBugs,
+		Refs:        docRefsField(fields),
+		URLs:        stringsField(fields, "urls"),
+
 		Highlights: SearchHighlights{
-			Name:    template.HTML(highlightName),
-			Content: template.HTML(highlightContent),
+			Name:    highlightName,
+			Content: highlightContent,
+
+			ContentField: highlightField,
+			Code:         highlightCode,
 		},
 	}, nil
 }
+
+// SourceViewLink returns the link to the in-app source view of a file,
+// pointing at the given line when it is known.
+func SourceViewLink(importPath, file string, line int) string {
+	if len(file) <= 0 {
+		return ""
+	}
+	link := "/source/" + path.Join(importPath, file)
+	if line > 0 {
+		link = fmt.Sprintf("%s#L%d", link, line)
+	}
+	return link
+}
+
+// stringsField returns the values of a stored field, which are a single
+// value or a list of them depending on how many of them there are.
+func stringsField(fields map[string]interface{}, name string) []string {
+	switch v := fields[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		ss := []string{}
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				ss = append(ss, s)
+			}
+		}
+		return ss
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package docindex
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/index"
+)
+
+const (
+	// ShardByHash spreads packages over the shards of an index by the hash
+	// of their import path.
+	ShardByHash = "hash"
+	// ShardByHost keeps the packages of a host in the same shard.
+	ShardByHost = "host"
+)
+
+// shardsConfigName is the file describing the shards of a sharded index.
+const shardsConfigName = "shards.json"
+
+// ShardConfig is how an index is split in shards.
+type ShardConfig struct {
+	Shards int    `json:"shards"`
+	By     string `json:"by"`
+}
+
+var shardConfig = ShardConfig{Shards: 1, By: ShardByHash}
+
+// SetShardConfig sets how new indexes are split in shards. Existing indexes
+// keep the shards they were created with.
+func SetShardConfig(shards int, by string) error {
+	if shards < 1 {
+		return fmt.Errorf("Invalid number of shards %d", shards)
+	}
+	if by != ShardByHash && by != ShardByHost {
+		return fmt.Errorf("Invalid shard key %q", by)
+	}
+	shardConfig = ShardConfig{Shards: shards, By: by}
+	return nil
+}
+
+// Index is the documentation index. It is made of one or more bleve indexes,
+// the shards, which are searched concurrently through an alias. Documents
+// are indexed into the shard of their package.
+type Index struct {
+	bleve.IndexAlias
+	shards []bleve.Index
+	config ShardConfig
+}
+
+func newShardedIndex(shards []bleve.Index, config ShardConfig) *Index {
+	return &Index{
+		IndexAlias: bleve.NewIndexAlias(shards...),
+		shards:     shards,
+		config:     config,
+	}
+}
+
+// Shards returns the shards of the index.
+func (idx *Index) Shards() []bleve.Index {
+	return idx.shards
+}
+
+// Index indexes a document into the shard of its package.
+func (idx *Index) Index(id string, data interface{}) error {
+	return idx.shardFor(docImportPath(id, data)).Index(id, data)
+}
+
+// Delete deletes a document from every shard, since the shard it was indexed
+// into can not be told by its identifier.
+func (idx *Index) Delete(id string) error {
+	for _, shard := range idx.shards {
+		err := shard.Delete(id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetInternal reads an internal value, which all shards share.
+func (idx *Index) GetInternal(key []byte) ([]byte, error) {
+	return idx.shards[0].GetInternal(key)
+}
+
+// SetInternal sets an internal value in every shard.
+func (idx *Index) SetInternal(key, val []byte) error {
+	for _, shard := range idx.shards {
+		err := shard.SetInternal(key, val)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes every shard.
+func (idx *Index) Close() error {
+	var firstErr error
+	for _, shard := range idx.shards {
+		err := shard.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// FieldDict returns the terms of a field in all the shards.
+func (idx *Index) FieldDict(field string) (index.FieldDict, error) {
+	return idx.mergedDict(func(shard bleve.Index) (index.FieldDict, error) {
+		return shard.FieldDict(field)
+	})
+}
+
+// FieldDictPrefix returns the terms of a field starting with a prefix in all
+// the shards.
+func (idx *Index) FieldDictPrefix(field string, termPrefix []byte) (index.FieldDict, error) {
+	return idx.mergedDict(func(shard bleve.Index) (index.FieldDict, error) {
+		return shard.FieldDictPrefix(field, termPrefix)
+	})
+}
+
+// mergedDict reads the dictionaries of all the shards, adding up the counts
+// of the terms found in several of them.
+func (idx *Index) mergedDict(open func(bleve.Index) (index.FieldDict, error)) (index.FieldDict, error) {
+	if len(idx.shards) == 1 {
+		return open(idx.shards[0])
+	}
+	counts := map[string]uint64{}
+	for _, shard := range idx.shards {
+		dict, err := open(shard)
+		if err != nil {
+			return nil, err
+		}
+		entry, err := dict.Next()
+		for err == nil && entry != nil {
+			counts[entry.Term] += entry.Count
+			entry, err = dict.Next()
+		}
+		dict.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	terms := make([]string, 0, len(counts))
+	for term := range counts {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+	merged := &mergedFieldDict{}
+	for _, term := range terms {
+		merged.entries = append(merged.entries, &index.DictEntry{Term: term, Count: counts[term]})
+	}
+	return merged, nil
+}
+
+// mergedFieldDict is a dictionary read in advance.
+type mergedFieldDict struct {
+	entries []*index.DictEntry
+}
+
+func (d *mergedFieldDict) Next() (*index.DictEntry, error) {
+	if len(d.entries) <= 0 {
+		return nil, nil
+	}
+	entry := d.entries[0]
+	d.entries = d.entries[1:]
+	return entry, nil
+}
+
+func (d *mergedFieldDict) Close() error {
+	return nil
+}
+
+func (idx *Index) shardFor(importPath string) bleve.Index {
+	if len(idx.shards) == 1 {
+		return idx.shards[0]
+	}
+	key := importPath
+	if idx.config.By == ShardByHost {
+		key = importHost(importPath)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return idx.shards[h.Sum32()%uint32(len(idx.shards))]
+}
+
+// docImportPath returns the import path of the package a document belongs
+// to. Unknown documents are spread by their identifier.
+func docImportPath(id string, data interface{}) string {
+	switch d := data.(type) {
+	case *Package:
+		return d.ImportPath
+	case *Func:
+		return d.ImportPath
+	case *Value:
+		return d.ImportPath
+	case *Type:
+		return d.ImportPath
+	}
+	return id
+}
+
+func shardPath(indexPath string, i int) string {
+	return filepath.Join(indexPath, fmt.Sprintf("shard-%03d", i))
+}
+
+// openShards opens an existing index. Indexes without a shards file are
+// plain bleve indexes, as unsharded indexes are created.
+func openShards(indexPath string) (*Index, error) {
+	data, err := ioutil.ReadFile(filepath.Join(indexPath, shardsConfigName))
+	if os.IsNotExist(err) {
+		shard, err := bleve.Open(indexPath)
+		if err != nil {
+			return nil, err
+		}
+		return newShardedIndex([]bleve.Index{shard}, ShardConfig{Shards: 1, By: ShardByHash}), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	config := ShardConfig{}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, err
+	}
+	shards := []bleve.Index{}
+	for i := 0; i < config.Shards; i++ {
+		shard, err := bleve.Open(shardPath(indexPath, i))
+		if err != nil {
+			for _, s := range shards {
+				s.Close()
+			}
+			return nil, err
+		}
+		shards = append(shards, shard)
+	}
+	return newShardedIndex(shards, config), nil
+}
+
+// createShards creates an index split as the current shard configuration
+// says.
+func createShards(indexPath string, mapping *bleve.IndexMapping) (*Index, error) {
+	config := shardConfig
+	if config.Shards == 1 {
+		shard, err := bleve.New(indexPath, mapping)
+		if err != nil {
+			return nil, err
+		}
+		return newShardedIndex([]bleve.Index{shard}, config), nil
+	}
+	err := os.MkdirAll(indexPath, 0755)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(filepath.Join(indexPath, shardsConfigName), data, 0644)
+	if err != nil {
+		return nil, err
+	}
+	shards := []bleve.Index{}
+	for i := 0; i < config.Shards; i++ {
+		shard, err := bleve.New(shardPath(indexPath, i), mapping)
+		if err != nil {
+			for _, s := range shards {
+				s.Close()
+			}
+			return nil, err
+		}
+		shards = append(shards, shard)
+	}
+	return newShardedIndex(shards, config), nil
+}
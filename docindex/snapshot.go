@@ -0,0 +1,168 @@
+package docindex
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Snapshot archives hold the index, the metadata store and the source store
+// under these names.
+const (
+	snapshotIndexDir   = "index"
+	snapshotMetaFile   = "meta"
+	snapshotSourcesDir = "sources"
+)
+
+// WriteSnapshot writes a gzipped tar archive with the index at indexPath, the
+// metadata store and the source store. Packages must not be indexed while
+// it is written, so the snapshot is consistent.
+func WriteSnapshot(w io.Writer, indexPath string, meta *MetaStore, sources *SourceStore) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	err := archiveDir(tw, indexPath, snapshotIndexDir)
+	if err != nil {
+		return err
+	}
+	err = meta.db.View(func(tx *bolt.Tx) error {
+		err := tw.WriteHeader(&tar.Header{
+			Name:    snapshotMetaFile,
+			Mode:    0644,
+			Size:    tx.Size(),
+			ModTime: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tx.WriteTo(tw)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	err = archiveDir(tw, sources.root, snapshotSourcesDir)
+	if err != nil {
+		return err
+	}
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// archiveDir adds the regular files under root to an archive, named after
+// their path relative to root under prefix.
+func archiveDir(tw *tar.Writer, root, prefix string) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(prefix, filepath.ToSlash(rel))
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.CopyN(tw, f, info.Size())
+		return err
+	})
+}
+
+// RestoreSnapshot extracts a snapshot written by WriteSnapshot, writing the
+// index to indexPath, the metadata store to metaPath and the source store to
+// sourcesPath. None of them can exist yet.
+func RestoreSnapshot(r io.Reader, indexPath, metaPath, sourcesPath string) error {
+	for _, p := range []string{indexPath, metaPath, sourcesPath} {
+		if _, err := os.Stat(p); err == nil {
+			return fmt.Errorf("Can not restore a snapshot over %s", p)
+		}
+	}
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		dest, err := snapshotDest(hdr.Name, indexPath, metaPath, sourcesPath)
+		if err != nil {
+			return err
+		}
+		err = extractFile(tr, dest, os.FileMode(hdr.Mode).Perm())
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// snapshotDest returns where a file of a snapshot has to be extracted.
+func snapshotDest(name, indexPath, metaPath, sourcesPath string) (string, error) {
+	clean := path.Clean(name)
+	if clean != name || path.IsAbs(clean) || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("Invalid snapshot file %q", name)
+	}
+	if clean == snapshotMetaFile {
+		return metaPath, nil
+	}
+	parts := strings.SplitN(clean, "/", 2)
+	if len(parts) == 2 {
+		switch parts[0] {
+		case snapshotIndexDir:
+			return filepath.Join(indexPath, filepath.FromSlash(parts[1])), nil
+		case snapshotSourcesDir:
+			return filepath.Join(sourcesPath, filepath.FromSlash(parts[1])), nil
+		}
+	}
+	return "", fmt.Errorf("Unexpected snapshot file %q", name)
+}
+
+func extractFile(r io.Reader, dest string, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
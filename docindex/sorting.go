@@ -0,0 +1,95 @@
+package docindex
+
+import (
+	"sort"
+	"strings"
+)
+
+// Sort orders of search results.
+const (
+	SortRelevance = "relevance"
+	SortName      = "name"
+	SortPackage   = "package"
+	SortRecent    = "recent"
+)
+
+// rankWindow is how many of the best matches are ranked. They are promoted
+// after the search, and sorted when sorted other than by relevance.
+const rankWindow = 100
+
+// ValidSortOrder reports whether a sort order is known. The empty one sorts
+// by relevance.
+func ValidSortOrder(order string) bool {
+	switch order {
+	case "", SortRelevance, SortName, SortPackage, SortRecent:
+		return true
+	}
+	return false
+}
+
+// sortResults sorts results, which are sorted by relevance, in the given
+// order. Results which are equal in that order keep their relevance order.
+func sortResults(results []*SearchResult, order string) {
+	switch order {
+	case SortName:
+		sort.Stable(byName(results))
+	case SortPackage:
+		sort.Stable(byPackage(results))
+	case SortRecent:
+		sort.Stable(byRecent(results))
+	}
+}
+
+// resultName returns the name of a result as it is sorted, which is qualified
+// by the receiver for methods.
+func resultName(r *SearchResult) string {
+	name := r.Name
+	if r.Type == MethodKind {
+		if i := strings.LastIndex(r.PageLink, "#"); i >= 0 {
+			name = r.PageLink[i+1:]
+		}
+	}
+	return strings.ToLower(name)
+}
+
+type byScore []*SearchResult
+
+func (rs byScore) Len() int           { return len(rs) }
+func (rs byScore) Swap(i, j int)      { rs[i], rs[j] = rs[j], rs[i] }
+func (rs byScore) Less(i, j int) bool { return rs[i].Score > rs[j].Score }
+
+type byName []*SearchResult
+
+func (rs byName) Len() int      { return len(rs) }
+func (rs byName) Swap(i, j int) { rs[i], rs[j] = rs[j], rs[i] }
+func (rs byName) Less(i, j int) bool {
+	return resultName(rs[i]) < resultName(rs[j])
+}
+
+// byPackage sorts results by import path, with the package itself first and
+// then its entries by name.
+type byPackage []*SearchResult
+
+func (rs byPackage) Len() int      { return len(rs) }
+func (rs byPackage) Swap(i, j int) { rs[i], rs[j] = rs[j], rs[i] }
+func (rs byPackage) Less(i, j int) bool {
+	a, b := rs[i], rs[j]
+	if a.ImportPath != b.ImportPath {
+		return a.ImportPath < b.ImportPath
+	}
+	aPkg := a.Type == PackageKind || a.Type == CommandKind
+	bPkg := b.Type == PackageKind || b.Type == CommandKind
+	if aPkg != bPkg {
+		return aPkg
+	}
+	return resultName(a) < resultName(b)
+}
+
+// byRecent sorts results by indexing time, the most recent first.
+type byRecent []*SearchResult
+
+func (rs byRecent) Len() int      { return len(rs) }
+func (rs byRecent) Swap(i, j int) { rs[i], rs[j] = rs[j], rs[i] }
+func (rs byRecent) Less(i, j int) bool {
+	return rs[i].Indexed.After(rs[j].Indexed)
+}
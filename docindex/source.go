@@ -0,0 +1,308 @@
+package docindex
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/doc"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/gddo/gosrc"
+)
+
+// Position is the location of a declaration in the package sourcecode.
+type Position struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Source string `json:"source"`
+}
+
+// Source locates declarations of a fetched package in its sourcecode.
+type Source struct {
+	fset       *token.FileSet
+	pkgPos     token.Pos
+	lineFmt    string
+	browseURLs map[string]string
+	// notes are the notes of the package documentation, once it is read.
+	notes map[string][]*doc.Note
+	// docs renders the doc comments of the package, once it is read.
+	docs *docRenderer
+}
+
+func newSource(dir *gosrc.Directory, fset *token.FileSet, pkg *ast.Package) *Source {
+	src := &Source{
+		fset:       fset,
+		lineFmt:    dir.LineFmt,
+		browseURLs: map[string]string{},
+	}
+	for _, file := range dir.Files {
+		src.browseURLs[file.Name] = file.BrowseURL
+	}
+	// The package is located at the package clause of the file holding its
+	// documentation, or the first file if there is none.
+	fnames := []string{}
+	for fname := range pkg.Files {
+		fnames = append(fnames, fname)
+	}
+	sort.Strings(fnames)
+	for _, fname := range fnames {
+		file := pkg.Files[fname]
+		if !src.pkgPos.IsValid() || file.Doc != nil {
+			src.pkgPos = file.Package
+		}
+		if file.Doc != nil {
+			break
+		}
+	}
+	return src
+}
+
+// Position returns the position of pos.
+func (src *Source) Position(pos token.Pos) Position {
+	if src == nil || !pos.IsValid() {
+		return Position{}
+	}
+	p := src.fset.Position(pos)
+	return Position{
+		File:   p.Filename,
+		Line:   p.Line,
+		Source: formatLine(src.lineFmt, src.browseURLs[p.Filename], p.Line),
+	}
+}
+
+// PackagePosition returns the position of the package clause.
+func (src *Source) PackagePosition() Position {
+	if src == nil {
+		return Position{}
+	}
+	return src.Position(src.pkgPos)
+}
+
+func formatLine(lineFmt, browseURL string, line int) string {
+	if len(browseURL) <= 0 {
+		return ""
+	}
+	if len(lineFmt) <= 0 || line <= 0 {
+		return browseURL
+	}
+	return fmt.Sprintf(lineFmt, browseURL, line)
+}
+
+/*
+Source storage
+*/
+
+const sourceManifestName = "_source.json"
+
+// SourceStore keeps a copy of the sourcecode of the indexed packages, one
+// directory per import path. Packages are not read while they are stored.
+type SourceStore struct {
+	mutex sync.RWMutex
+	root  string
+}
+
+type sourceManifest struct {
+	LineFmt    string            `json:"lineFmt"`
+	BrowseURLs map[string]string `json:"browseURLs"`
+}
+
+// NewSourceStore opens (or creates) a source store rooted at root.
+func NewSourceStore(root string) (*SourceStore, error) {
+	err := os.MkdirAll(root, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &SourceStore{root: root}, nil
+}
+
+// PutDirectory stores all files of a fetched directory, replacing the ones
+// previously stored for the same import path.
+func (store *SourceStore) PutDirectory(dir *gosrc.Directory) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	pkgDir, err := store.packageDir(dir.ImportPath)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(pkgDir, 0755)
+	if err != nil {
+		return err
+	}
+	// Subpackages live in subdirectories, so only files are removed
+	infos, err := ioutil.ReadDir(pkgDir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		err := os.Remove(filepath.Join(pkgDir, info.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	manifest := sourceManifest{
+		LineFmt:    dir.LineFmt,
+		BrowseURLs: map[string]string{},
+	}
+	for _, file := range dir.Files {
+		if !validFileName(file.Name) {
+			continue
+		}
+		err := ioutil.WriteFile(filepath.Join(pkgDir, file.Name), file.Data, 0644)
+		if err != nil {
+			return err
+		}
+		manifest.BrowseURLs[file.Name] = file.BrowseURL
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(pkgDir, sourceManifestName), data, 0644)
+}
+
+// File returns the content of a stored source file.
+func (store *SourceStore) File(importPath, name string) ([]byte, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	return store.file(importPath, name)
+}
+
+func (store *SourceStore) file(importPath, name string) ([]byte, error) {
+	if !validFileName(name) {
+		return nil, errors.New("Invalid file name")
+	}
+	pkgDir, err := store.packageDir(importPath)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(filepath.Join(pkgDir, name))
+}
+
+// BrowseURL returns the location of a stored source file in its repository
+// web site, or an empty string if it is unknown.
+func (store *SourceStore) BrowseURL(importPath, name string, line int) string {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	pkgDir, err := store.packageDir(importPath)
+	if err != nil {
+		return ""
+	}
+	data, err := ioutil.ReadFile(filepath.Join(pkgDir, sourceManifestName))
+	if err != nil {
+		return ""
+	}
+	manifest := sourceManifest{}
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return ""
+	}
+	return formatLine(manifest.LineFmt, manifest.BrowseURLs[name], line)
+}
+
+// Directory rebuilds the directory a stored package was fetched as.
+func (store *SourceStore) Directory(importPath string) (*gosrc.Directory, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	pkgDir, err := store.packageDir(importPath)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(pkgDir, sourceManifestName))
+	if err != nil {
+		return nil, err
+	}
+	manifest := sourceManifest{}
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	names, err := store.files(importPath)
+	if err != nil {
+		return nil, err
+	}
+	dir := &gosrc.Directory{
+		ImportPath: importPath,
+		LineFmt:    manifest.LineFmt,
+	}
+	for _, name := range names {
+		data, err := store.file(importPath, name)
+		if err != nil {
+			return nil, err
+		}
+		dir.Files = append(dir.Files, &gosrc.File{
+			Name:      name,
+			Data:      data,
+			BrowseURL: manifest.BrowseURLs[name],
+		})
+	}
+	return dir, nil
+}
+
+// Packages returns the import paths of all the stored packages.
+func (store *SourceStore) Packages() ([]string, error) {
+	pkgs := []string{}
+	err := filepath.Walk(store.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Name() != sourceManifestName {
+			return nil
+		}
+		rel, err := filepath.Rel(store.root, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		pkgs = append(pkgs, filepath.ToSlash(rel))
+		return nil
+	})
+	return pkgs, err
+}
+
+// Files returns the names of the stored files of a package.
+func (store *SourceStore) Files(importPath string) ([]string, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	return store.files(importPath)
+}
+
+func (store *SourceStore) files(importPath string) ([]string, error) {
+	pkgDir, err := store.packageDir(importPath)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(pkgDir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, info := range infos {
+		if !info.IsDir() && validFileName(info.Name()) {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
+
+func (store *SourceStore) packageDir(importPath string) (string, error) {
+	clean := path.Clean("/" + importPath)[1:]
+	if len(clean) <= 0 || clean != importPath {
+		return "", fmt.Errorf("Invalid import path %q", importPath)
+	}
+	return filepath.Join(store.root, filepath.FromSlash(clean)), nil
+}
+
+func validFileName(name string) bool {
+	return len(name) > 0 && name != sourceManifestName &&
+		!strings.ContainsAny(name, `/\`) && name != "." && name != ".."
+}
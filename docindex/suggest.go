@@ -0,0 +1,215 @@
+package docindex
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/blevesearch/bleve"
+)
+
+// Suggestion is a spelling correction of a query, made of the indexed terms
+// closest to its words.
+type Suggestion struct {
+	Query string `json:"query"`
+	Link  string `json:"link"`
+	// Confident is set when the correction is very likely what was meant.
+	Confident bool `json:"confident"`
+	// Applied is set when the results are the ones of the correction, since
+	// the query had none and the correction is confident.
+	Applied bool `json:"applied"`
+}
+
+// minSuggestLen is the length of the shortest word which gets corrected.
+// Shorter words have too many neighbours to pick one.
+const minSuggestLen = 4
+
+// suggestionFields are the fields whose terms are the vocabulary corrections
+// are taken from. Names are kept as written, while doc terms are lowercased
+// and may keep punctuation, so only those which are words are suggested.
+var suggestionFields = []struct {
+	name      string
+	lowercase bool
+}{
+	{"name", false},
+	{"doc", true},
+}
+
+// wordCorrection is the closest indexed term to a word.
+type wordCorrection struct {
+	term     string
+	distance int
+	count    uint64
+	// rivalCount is the count of the most frequent other term at the same
+	// distance.
+	rivalCount uint64
+}
+
+// suggest corrects the words of a query which are not indexed. Filters are
+// kept as they are. It returns nil if there is nothing to correct.
+func suggest(index bleve.Index, queryString string) (*Suggestion, error) {
+	words := strings.Fields(queryString)
+	changed := false
+	confident := true
+	for i, word := range words {
+		if _, ok := parseFilter(word); ok || utf8.RuneCountInString(word) < minSuggestLen {
+			continue
+		}
+		c, err := correctWord(index, word)
+		if err != nil {
+			return nil, err
+		}
+		if c == nil {
+			continue
+		}
+		words[i] = c.term
+		changed = true
+		if c.distance > 1 || c.count < 2*c.rivalCount {
+			confident = false
+		}
+	}
+	if !changed {
+		return nil, nil
+	}
+	return &Suggestion{Query: strings.Join(words, " "), Confident: confident}, nil
+}
+
+// correctWord returns the indexed term closest to a word, or nil if the word
+// is indexed or nothing is close enough. Candidates start like the word, as
+// misspellings rarely change the first letter.
+func correctWord(index bleve.Index, word string) (*wordCorrection, error) {
+	maxDistance := 1
+	if utf8.RuneCountInString(word) > 5 {
+		maxDistance = 2
+	}
+	var best *wordCorrection
+	for _, field := range suggestionFields {
+		term := word
+		if field.lowercase {
+			term = strings.ToLower(word)
+		}
+		for _, prefix := range candidatePrefixes(term, field.lowercase) {
+			dict, err := index.FieldDictPrefix(field.name, []byte(prefix))
+			if err != nil {
+				return nil, err
+			}
+			entry, err := dict.Next()
+			for err == nil && entry != nil {
+				if entry.Term == term {
+					dict.Close()
+					return nil, nil
+				}
+				if !isIdentifierTerm(entry.Term) {
+					entry, err = dict.Next()
+					continue
+				}
+				d := levenshtein(strings.ToLower(term), strings.ToLower(entry.Term), maxDistance)
+				if d <= maxDistance {
+					best = betterCorrection(best, entry.Term, d, entry.Count)
+				}
+				entry, err = dict.Next()
+			}
+			dict.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return best, nil
+}
+
+// isIdentifierTerm reports whether a term is made of letters, digits and
+// underscores only, unlike doc terms such as "writer," or "(see".
+func isIdentifierTerm(term string) bool {
+	for _, r := range term {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return term != ""
+}
+
+// candidatePrefixes returns the first letter of a term and, unless the field
+// is lowercased, the same letter in the other case, so "bufer" finds Buffer.
+func candidatePrefixes(term string, lowercase bool) []string {
+	r, size := utf8.DecodeRuneInString(term)
+	prefixes := []string{term[:size]}
+	if lowercase {
+		return prefixes
+	}
+	other := unicode.ToUpper(r)
+	if other == r {
+		other = unicode.ToLower(r)
+	}
+	if other != r {
+		prefixes = append(prefixes, string(other))
+	}
+	return prefixes
+}
+
+// betterCorrection returns the best of a correction and a candidate term:
+// the closest one, or the most frequent one if they are equally close.
+func betterCorrection(c *wordCorrection, term string, distance int, count uint64) *wordCorrection {
+	switch {
+	case c == nil || distance < c.distance:
+		return &wordCorrection{term: term, distance: distance, count: count}
+	case distance > c.distance || strings.EqualFold(term, c.term):
+		return c
+	case count > c.count:
+		return &wordCorrection{term: term, distance: distance, count: count, rivalCount: c.count}
+	}
+	if count > c.rivalCount {
+		c.rivalCount = count
+	}
+	return c
+}
+
+// levenshtein returns the edit distance between two strings, or any value
+// over max once it is known to be over max.
+func levenshtein(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if abs(len(ra)-len(rb)) > max {
+		return max + 1
+	}
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if cur[j] < rowMin {
+				rowMin = cur[j]
+			}
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -1,38 +1,17 @@
 package docindex
 
-import (
-	"bytes"
+import "strings"
 
-	"golang.org/x/net/html"
-)
-
-func removeDocSourcecode(text string) string {
-	buf := bytes.NewBufferString(text)
-	root, err := html.Parse(buf)
-	if err != nil {
-		return text
-	}
-	var visit func(n *html.Node)
-	visit = func(n *html.Node) {
-		if n == nil {
-			return
-		}
-		if n.Type == html.ElementNode && n.Data == "pre" {
-			if n.Parent != nil {
-				next := n.NextSibling
-				n.Parent.RemoveChild(n)
-				visit(next)
-			}
-		}
-		if n.FirstChild != nil {
-			visit(n.FirstChild)
-		}
-		if n.NextSibling != nil {
-			visit(n.NextSibling)
+// deprecationNotice looks for a paragraph starting with "Deprecated:" in a doc
+// comment, as the Go convention for deprecated APIs says, and returns its
+// message.
+func deprecationNotice(text string) (bool, string) {
+	for _, par := range strings.Split(text, "\n\n") {
+		par = strings.TrimSpace(par)
+		if strings.HasPrefix(par, "Deprecated:") {
+			msg := strings.TrimSpace(strings.TrimPrefix(par, "Deprecated:"))
+			return true, strings.Join(strings.Fields(msg), " ")
 		}
 	}
-	visit(root)
-	rbuf := new(bytes.Buffer)
-	html.Render(rbuf, root)
-	return rbuf.String()
+	return false, ""
 }
@@ -2,15 +2,22 @@ package main
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"html/template"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/blevesearch/bleve"
 	"github.com/gophergala/ging/docindex"
@@ -21,6 +28,7 @@ import (
 
 const (
 	githubAccessTokenVarName = "GING_GITHUB_ACCESSTOKEN"
+	adminTokenVarName        = "GING_ADMIN_TOKEN"
 )
 
 var (
@@ -28,20 +36,67 @@ var (
 	resourcesPath   = flag.String("resources-path", "resources/", "Resources path")
 	indexPrefix     = flag.String("index-prefix", ".", "Indexes path")
 	docindexName    = flag.String("docindex", "docindex.bleve", "Docindex path")
+	sourcesName     = flag.String("sources", "sources", "Package sources path")
+	metaName        = flag.String("meta", "docindex.meta", "Package metadata path")
 	localDevMode    = flag.Bool("local", false, "Enable local development mode")
 	fetchFilePath   = flag.String("fetch-file", "", "Fetch and index package from the specified file")
+	linksFilePath   = flag.String("links", "", "Load documentation link templates from the specified file")
+	platforms       = flag.String("platforms", "", "Comma separated list of goos/goarch pairs to index packages for")
+	snapshotPath    = flag.String("snapshot", "", "Write a snapshot of the index, package metadata and sources to the specified file and exit")
+	restorePath     = flag.String("restore", "", "Restore the index, package metadata and sources from the specified snapshot before starting")
+	shards          = flag.Int("shards", 1, "Number of shards of new indexes")
+	shardBy         = flag.String("shard-by", docindex.ShardByHash, "How packages are assigned to shards of new indexes: hash or host")
+	migrateIndex    = flag.Bool("migrate", false, "Rebuild the index in the background if it was built with an older mapping")
+	refreshInterval = flag.Duration("refresh", 0, "Interval between checks of indexed packages for changes, 0 disables them")
 	templates       *template.Template
-	index           bleve.Index
+	index           bleve.IndexAlias
+	sources         *docindex.SourceStore
+	meta            *docindex.MetaStore
+	codeIndex       = docindex.NewCodeIndex()
 	indexationMutex = new(sync.Mutex)
 )
 
 func main() {
 	var err error
-	index, err = docindex.OpenOrCreateIndex(path.Join(*indexPrefix, *docindexName))
+	loadLinkTemplates()
+	if len(*platforms) > 0 {
+		err = docindex.SetBuildEnvs(strings.Split(*platforms, ","))
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+	}
+	err = docindex.SetShardConfig(*shards, *shardBy)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	if len(*restorePath) > 0 {
+		restoreSnapshot()
+	}
+	sources, err = docindex.NewSourceStore(path.Join(*indexPrefix, *sourcesName))
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	meta, err = docindex.OpenMetaStore(path.Join(*indexPrefix, *metaName))
 	if err != nil {
 		log.Fatalln(err.Error())
 	}
+	err = openLiveIndex()
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	if len(*snapshotPath) > 0 {
+		writeSnapshotFile()
+		return
+	}
+	err = codeIndex.Load(sources)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	log.Printf("Code index loaded with %d packages\n", codeIndex.Packages())
 	fetchPackagesFromFetchFile()
+	if *refreshInterval > 0 {
+		go refreshPackages(*refreshInterval)
+	}
 
 	http.HandleFunc("/", indexHandler)
 	http.HandleFunc("/humans.txt", humansHandler)
@@ -50,6 +105,15 @@ func main() {
 	http.HandleFunc("/query", queryHandler)
 	http.HandleFunc("/stream/query", queryStreamHandler)
 	http.HandleFunc("/package/add", addPackageHandle)
+	http.HandleFunc("/source/", sourceHandler)
+	http.HandleFunc("/pkg/", packageHandler)
+	http.HandleFunc("/code", codeHandler)
+	http.HandleFunc("/api/v1/packages", apiPackagesHandler)
+	http.HandleFunc("/api/v1/search", apiSearchHandler)
+	http.HandleFunc("/api/v1/complete", apiCompleteHandler)
+	http.HandleFunc("/admin/snapshot", snapshotHandler)
+	http.HandleFunc("/admin/reindex", reindexHandler)
+	http.HandleFunc("/admin/rollback", rollbackHandler)
 
 	log.Printf("Listening on port %d\n", *port)
 	err = http.ListenAndServe(fmt.Sprintf(":%d", *port), nil)
@@ -58,6 +122,63 @@ func main() {
 	}
 }
 
+func loadLinkTemplates() {
+	if len(*linksFilePath) <= 0 {
+		return
+	}
+	f, err := os.Open(*linksFilePath)
+	if err != nil {
+		log.Fatalf("Error opening links file: %s.\n", err.Error())
+	}
+	defer f.Close()
+	err = docindex.LoadLinkTemplates(f)
+	if err != nil {
+		log.Fatalf("Error reading links file: %s.\n", err.Error())
+	}
+}
+
+func restoreSnapshot() {
+	f, err := os.Open(*restorePath)
+	if err != nil {
+		log.Fatalf("Error opening snapshot: %s.\n", err.Error())
+	}
+	defer f.Close()
+	err = docindex.RestoreSnapshot(f,
+		path.Join(*indexPrefix, *docindexName),
+		path.Join(*indexPrefix, *metaName),
+		path.Join(*indexPrefix, *sourcesName))
+	if err != nil {
+		log.Fatalf("Error restoring snapshot: %s.\n", err.Error())
+	}
+	// The restored index is the one to serve, whatever was served before
+	err = os.Remove(path.Join(*indexPrefix, *docindexName+currentIndexSuffix))
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatalf("Error restoring snapshot: %s.\n", err.Error())
+	}
+	log.Printf("Snapshot %s restored\n", *restorePath)
+}
+
+func writeSnapshotFile() {
+	f, err := os.Create(*snapshotPath)
+	if err != nil {
+		log.Fatalf("Error creating snapshot: %s.\n", err.Error())
+	}
+	defer f.Close()
+	err = writeSnapshot(f)
+	if err != nil {
+		log.Fatalf("Error writing snapshot: %s.\n", err.Error())
+	}
+	log.Printf("Snapshot written to %s\n", *snapshotPath)
+}
+
+// writeSnapshot writes a snapshot, holding indexation meanwhile so it is
+// consistent.
+func writeSnapshot(w io.Writer) error {
+	indexationMutex.Lock()
+	defer indexationMutex.Unlock()
+	return docindex.WriteSnapshot(w, liveIndexPath, meta, sources)
+}
+
 func fetchPackagesFromFetchFile() {
 	if len(*fetchFilePath) <= 0 {
 		return
@@ -94,14 +215,43 @@ func fetchPackage(pacakgePath string) {
 		}
 		client = oauth2.NewClient(oauth2.NoContext, tokenSource)
 	}
-	err := docindex.IndexPackage(client, index, pacakgePath)
+	err := docindex.IndexPackage(client, index, sources, meta, pacakgePath)
+	if err == docindex.ErrNotModified {
+		log.Printf("Package %s not modified.\n", pacakgePath)
+		return
+	}
 	if err != nil {
 		log.Printf("Error indexing package %s: %s.\n", pacakgePath, err.Error())
 		return
 	}
+	err = codeIndex.AddPackage(sources, pacakgePath)
+	if err != nil {
+		log.Printf("Error indexing code of package %s: %s.\n", pacakgePath, err.Error())
+	}
 	log.Printf("Package %s indexed.\n", pacakgePath)
 }
 
+// refreshTick is how often the refresher looks for packages due to be
+// checked.
+const refreshTick = time.Minute
+
+// refreshPackages checks the indexed packages for changes forever, each one
+// once per interval.
+func refreshPackages(interval time.Duration) {
+	for range time.Tick(refreshTick) {
+		due, err := meta.DuePackages(interval, time.Now())
+		if err != nil {
+			log.Printf("Error looking for packages to refresh: %s.\n", err.Error())
+			continue
+		}
+		for _, importPath := range due {
+			indexationMutex.Lock()
+			fetchPackage(importPath)
+			indexationMutex.Unlock()
+		}
+	}
+}
+
 func init() {
 	flag.Parse()
 	templates = template.Must(template.ParseFiles(
@@ -111,7 +261,11 @@ func init() {
 		path.Join(*resourcesPath, "templates/scripts.html"),
 		path.Join(*resourcesPath, "templates/query.html"),
 		path.Join(*resourcesPath, "templates/query-results.html"),
+		path.Join(*resourcesPath, "templates/query-facets.html"),
 		path.Join(*resourcesPath, "templates/package-add.html"),
+		path.Join(*resourcesPath, "templates/source.html"),
+		path.Join(*resourcesPath, "templates/code.html"),
+		path.Join(*resourcesPath, "templates/package.html"),
 	))
 }
 
@@ -131,23 +285,33 @@ func queryHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
+	opts, err := searchOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Searches are always linkable, options included
 	if len(r.URL.Query()) <= 0 {
-		http.Redirect(w, r, "/query?"+r.Form.Encode(), http.StatusTemporaryRedirect)
+		http.Redirect(w, r, opts.Link(queryString), http.StatusSeeOther)
 		return
 	}
 
-	results, sr, err := docindex.Search(index, queryString)
+	results, err := docindex.Search(index, queryString, opts)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	subtitle :=
-		fmt.Sprintf("<strong>%d</strong> results in <strong>%s</strong>", sr.Total, sr.Took)
+		fmt.Sprintf("<strong>%d</strong> results in <strong>%s</strong>", results.Total, results.Took)
 	values := map[string]interface{}{
 		"ShowNoResultAlert": len(queryString) > 0,
 		"QueryValue":        queryString,
+		"Options":           opts,
 		"Subtitle":          template.HTML(subtitle),
-		"Results":           results,
+		"Results":           results.Results,
+		"Facets":            results.Facets,
+		"Suggestion":        results.Suggestion,
+		"SortedTop":         results.SortedTop,
 	}
 	err = templates.ExecuteTemplate(w, "query.html", values)
 	if err != nil {
@@ -156,6 +320,22 @@ func queryHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// searchOptions reads the options of a search from the parameters of a
+// request.
+func searchOptions(r *http.Request) (docindex.SearchOptions, error) {
+	opts := docindex.SearchOptions{
+		Sort:    r.FormValue("sort"),
+		Host:    strings.TrimSpace(r.FormValue("host")),
+		Std:     r.FormValue("std") == "1",
+		Explain: r.FormValue("explain") == "1",
+		Suggest: true,
+	}
+	if !docindex.ValidSortOrder(opts.Sort) {
+		return opts, fmt.Errorf("Unknown sort order %q", opts.Sort)
+	}
+	return opts, nil
+}
+
 func addPackageHandle(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	packageName := r.FormValue("package")
@@ -175,6 +355,218 @@ func addPackageHandle(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func sourceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	filePath := strings.TrimPrefix(r.URL.Path, "/source/")
+	importPath, fileName := path.Split(filePath)
+	importPath = strings.TrimSuffix(importPath, "/")
+	data, err := sources.File(importPath, fileName)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	values := map[string]interface{}{
+		"ImportPath": importPath,
+		"FileName":   fileName,
+		"BrowseURL":  sources.BrowseURL(importPath, fileName, 0),
+		"Lines":      docindex.HighlightSource(data),
+	}
+	err = templates.ExecuteTemplate(w, "source.html", values)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func packageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	importPath := strings.TrimPrefix(r.URL.Path, "/pkg/")
+	page, err := docindex.LoadPackagePage(index, importPath)
+	if err == docindex.ErrPackageNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = templates.ExecuteTemplate(w, "package.html", page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+const maxCodeMatches = 100
+
+func codeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	queryString := r.FormValue("q")
+	isRegexp := r.FormValue("re") == "1"
+	values := map[string]interface{}{
+		"QueryValue": queryString,
+		"Regexp":     isRegexp,
+	}
+	if len(queryString) > 0 {
+		start := time.Now()
+		matches, err := codeIndex.Search(queryString, isRegexp, maxCodeMatches)
+		if err != nil {
+			values["Error"] = err.Error()
+		}
+		values["Matches"] = matches
+		values["ShowNoResultAlert"] = err == nil
+		values["Subtitle"] = template.HTML(fmt.Sprintf(
+			"<strong>%d</strong> matches in <strong>%s</strong>", len(matches), time.Since(start)))
+	}
+	err := templates.ExecuteTemplate(w, "code.html", values)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// apiSearchHandler searches the index, returning the results and their
+// facets.
+func apiSearchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	queryString := r.FormValue("q")
+	if len(queryString) <= 0 {
+		http.Error(w, "Missing query", http.StatusBadRequest)
+		return
+	}
+	opts, err := searchOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	results, err := docindex.Search(index, queryString, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(struct {
+		Query      string                   `json:"query"`
+		Total      uint64                   `json:"total"`
+		Took       string                   `json:"took"`
+		Results    []*docindex.SearchResult `json:"results"`
+		Facets     []*docindex.Facet        `json:"facets"`
+		Suggestion *docindex.Suggestion     `json:"suggestion,omitempty"`
+		SortedTop  int                      `json:"sorted_top,omitempty"`
+	}{
+		Query:      queryString,
+		Total:      results.Total,
+		Took:       results.Took.String(),
+		Results:    results.Results,
+		Facets:     results.Facets,
+		Suggestion: results.Suggestion,
+		SortedTop:  results.SortedTop,
+	})
+	if err != nil {
+		log.Printf("Error writing search results: %s.\n", err.Error())
+	}
+}
+
+// defaultCompletions is how many completions are returned when the request
+// does not say.
+const defaultCompletions = 10
+
+// apiCompleteHandler returns the symbols whose name starts with a prefix.
+func apiCompleteHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	n := defaultCompletions
+	if value := r.FormValue("n"); len(value) > 0 {
+		var err error
+		n, err = strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid number of completions", http.StatusBadRequest)
+			return
+		}
+	}
+	completions, err := docindex.Complete(index, r.FormValue("q"), n)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(completions)
+	if err != nil {
+		log.Printf("Error writing completions: %s.\n", err.Error())
+	}
+}
+
+// apiPackagesHandler lists the records of the indexed packages, optionally
+// those under an import path prefix or with a given status.
+func apiPackagesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	recs, err := meta.List(r.FormValue("prefix"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if status := r.FormValue("status"); len(status) > 0 {
+		filtered := []*docindex.PackageRecord{}
+		for _, rec := range recs {
+			if string(rec.Status) == status {
+				filtered = append(filtered, rec)
+			}
+		}
+		recs = filtered
+	}
+	err = json.NewEncoder(w).Encode(struct {
+		Total    int                       `json:"total"`
+		Packages []*docindex.PackageRecord `json:"packages"`
+	}{
+		Total:    len(recs),
+		Packages: recs,
+	})
+	if err != nil {
+		log.Printf("Error writing packages listing: %s.\n", err.Error())
+	}
+}
+
+// isAdmin reports whether a request carries the admin token. Admin endpoints
+// are disabled when no token is set.
+func isAdmin(r *http.Request) bool {
+	token := os.Getenv(adminTokenVarName)
+	if len(token) <= 0 {
+		return false
+	}
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+func snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	// The snapshot is written to a file first, so indexation is only held
+	// while it is written and not while it is downloaded
+	f, err := ioutil.TempFile(*indexPrefix, "ging-snapshot-")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	err = writeSnapshot(f)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		log.Printf("Error writing snapshot: %s.\n", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(
+		"attachment; filename=ging-snapshot-%s.tar.gz", time.Now().Format("20060102-150405")))
+	_, err = io.Copy(w, f)
+	if err != nil {
+		// The archive is already being sent, so the client only sees it cut
+		log.Printf("Error sending snapshot: %s.\n", err.Error())
+	}
+}
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
@@ -191,8 +583,20 @@ func queryStreamHandler(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			return
 		}
+		// Messages are either a query or a form encoded query and options
 		queryString := string(p)
-		results, _, err := docindex.Search(index, queryString)
+		opts := docindex.SearchOptions{}
+		if form, err := url.ParseQuery(queryString); err == nil && len(form.Get("query")) > 0 {
+			queryString = form.Get("query")
+			opts, err = searchOptions(&http.Request{Form: form})
+			if err != nil {
+				return
+			}
+		}
+		// Queries are searched while typed, so their words are often
+		// incomplete and not worth correcting
+		opts.Suggest = false
+		results, err := docindex.Search(index, queryString, opts)
 		if err != nil {
 			return
 		}
@@ -202,7 +606,11 @@ func queryStreamHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		values := map[string]interface{}{
 			"QueryValue": queryString,
-			"Results":    results,
+			"Options":    opts,
+			"Results":    results.Results,
+			"Facets":     results.Facets,
+			"Suggestion": results.Suggestion,
+			"SortedTop":  results.SortedTop,
 		}
 		buf := new(bytes.Buffer)
 		err = templates.ExecuteTemplate(buf, "query-results.html", values)
@@ -210,7 +618,7 @@ func queryStreamHandler(w http.ResponseWriter, r *http.Request) {
 			Query  string `json:"query"`
 			Result string `json:"result"`
 		}{
-			Query:  queryString,
+			Query:  string(p),
 			Result: buf.String(),
 		})
 		nw.Close()
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/gophergala/ging/docindex"
+)
+
+// Rebuilt indexes live next to the original one. A pointer file, named after
+// the -docindex flag, records which of them is being served.
+const currentIndexSuffix = ".current"
+
+var (
+	// reindexMutex guards the indexes below, which are the ones behind the
+	// index alias.
+	reindexMutex      = new(sync.Mutex)
+	reindexing        bool
+	liveIndex         bleve.Index
+	liveIndexPath     string
+	previousIndex     bleve.Index
+	previousIndexPath string
+	swappedAt         time.Time
+)
+
+// openLiveIndex opens the index being served and puts it behind the index
+// alias. An index built with an older mapping is only served with -migrate,
+// which rebuilds it in the background, so the package sources and metadata
+// have to be opened before.
+func openLiveIndex() error {
+	p := currentIndexPath()
+	idx, err := docindex.OpenOrCreateIndex(p)
+	migrate := false
+	if verr, ok := err.(*docindex.MappingVersionError); ok {
+		if !*migrateIndex {
+			idx.Close()
+			return fmt.Errorf("%s. Start with -migrate to rebuild it from the stored sources", verr.Error())
+		}
+		log.Printf("%s. Serving it while it is rebuilt.\n", verr.Error())
+		migrate, err = true, nil
+	}
+	if err != nil {
+		return err
+	}
+	liveIndex, liveIndexPath = idx, p
+	index = bleve.NewIndexAlias(idx)
+	if migrate {
+		reindexing = true
+		go rebuildIndex()
+	}
+	return nil
+}
+
+func currentIndexPath() string {
+	data, err := ioutil.ReadFile(path.Join(*indexPrefix, *docindexName+currentIndexSuffix))
+	if err != nil {
+		return path.Join(*indexPrefix, *docindexName)
+	}
+	return path.Join(*indexPrefix, strings.TrimSpace(string(data)))
+}
+
+func setCurrentIndexPath(p string) error {
+	pointer := path.Join(*indexPrefix, *docindexName+currentIndexSuffix)
+	err := ioutil.WriteFile(pointer+".tmp", []byte(filepath.Base(p)+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(pointer+".tmp", pointer)
+}
+
+// rebuildIndex builds a new index from the stored sources and metadata and
+// swaps it in. Search keeps using the live index meanwhile.
+func rebuildIndex() {
+	start := time.Now()
+	newPath := path.Join(*indexPrefix,
+		fmt.Sprintf("%s.%s", *docindexName, start.Format("20060102-150405")))
+	log.Printf("Rebuilding index at %s\n", newPath)
+	newIndex, err := docindex.RebuildIndex(newPath, sources, meta)
+	if err != nil {
+		log.Printf("Error rebuilding index: %s.\n", err.Error())
+		reindexMutex.Lock()
+		reindexing = false
+		reindexMutex.Unlock()
+		return
+	}
+
+	indexationMutex.Lock()
+	defer indexationMutex.Unlock()
+	reindexMutex.Lock()
+	defer reindexMutex.Unlock()
+	reindexing = false
+	// Packages indexed while rebuilding only made it to the live index
+	catchUpIndex(newIndex, start)
+	err = setCurrentIndexPath(newPath)
+	if err != nil {
+		log.Printf("Error recording index %s: %s.\n", newPath, err.Error())
+		newIndex.Close()
+		return
+	}
+	index.Swap([]bleve.Index{newIndex}, []bleve.Index{liveIndex})
+	if previousIndex != nil {
+		previousIndex.Close()
+		err := os.RemoveAll(previousIndexPath)
+		if err != nil {
+			log.Printf("Error removing index %s: %s.\n", previousIndexPath, err.Error())
+		}
+	}
+	previousIndex, previousIndexPath = liveIndex, liveIndexPath
+	liveIndex, liveIndexPath = newIndex, newPath
+	swappedAt = time.Now()
+	log.Printf("Index %s swapped in, %s kept for rollback\n", liveIndexPath, previousIndexPath)
+}
+
+// rollbackIndex serves the previous index again. The rolled back index is
+// kept as the previous one, so a rollback can be undone.
+func rollbackIndex() error {
+	indexationMutex.Lock()
+	defer indexationMutex.Unlock()
+	reindexMutex.Lock()
+	defer reindexMutex.Unlock()
+	if previousIndex == nil {
+		return errors.New("There is no previous index")
+	}
+	catchUpIndex(previousIndex, swappedAt)
+	err := setCurrentIndexPath(previousIndexPath)
+	if err != nil {
+		return err
+	}
+	index.Swap([]bleve.Index{previousIndex}, []bleve.Index{liveIndex})
+	previousIndex, liveIndex = liveIndex, previousIndex
+	previousIndexPath, liveIndexPath = liveIndexPath, previousIndexPath
+	swappedAt = time.Now()
+	log.Printf("Index rolled back to %s\n", liveIndexPath)
+	return nil
+}
+
+// catchUpIndex reindexes from their stored sources the packages fetched
+// since a given time.
+func catchUpIndex(idx bleve.Index, since time.Time) {
+	recs, err := meta.List("")
+	if err != nil {
+		log.Printf("Error listing packages: %s.\n", err.Error())
+		return
+	}
+	for _, rec := range recs {
+		if rec.FetchedAt.Before(since) {
+			continue
+		}
+		err := docindex.ReindexStoredPackage(idx, sources, meta, rec.ImportPath)
+		if err != nil {
+			log.Printf("Error reindexing package %s: %s.\n", rec.ImportPath, err.Error())
+		}
+	}
+}
+
+type indexStatus struct {
+	Live       string `json:"live"`
+	Previous   string `json:"previous"`
+	Reindexing bool   `json:"reindexing"`
+}
+
+func writeIndexStatus(w http.ResponseWriter, code int) {
+	reindexMutex.Lock()
+	status := indexStatus{
+		Live:       liveIndexPath,
+		Previous:   previousIndexPath,
+		Reindexing: reindexing,
+	}
+	reindexMutex.Unlock()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// reindexHandler reports the indexes being used and, on POST, starts
+// rebuilding the index.
+func reindexHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		writeIndexStatus(w, http.StatusOK)
+		return
+	}
+	reindexMutex.Lock()
+	running := reindexing
+	reindexing = true
+	reindexMutex.Unlock()
+	if running {
+		writeIndexStatus(w, http.StatusConflict)
+		return
+	}
+	go rebuildIndex()
+	writeIndexStatus(w, http.StatusAccepted)
+}
+
+func rollbackHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	err := rollbackIndex()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeIndexStatus(w, http.StatusOK)
+}
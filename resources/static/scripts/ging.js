@@ -24,7 +24,25 @@ if (submitElement && queryElement) {
     resultsElement.innerHTML = data.result;
   };
 
-  queryElement.addEventListener("input", function(e) {
+  // searchMessage returns the query and the search options, form encoded
+  var searchMessage = function(queryString) {
+    var message = "query=" + encodeURIComponent(queryString);
+    var sortElement = document.getElementById("sort");
+    var hostElement = document.getElementById("host");
+    var stdElement = document.getElementById("std");
+    if (sortElement) {
+      message += "&sort=" + encodeURIComponent(sortElement.value);
+    }
+    if (hostElement && hostElement.value.trim()) {
+      message += "&host=" + encodeURIComponent(hostElement.value.trim());
+    }
+    if (stdElement && stdElement.checked) {
+      message += "&std=1";
+    }
+    return message;
+  };
+
+  var search = function() {
     clearTimeout(autoQuery);
     autoQuery = setTimeout(function() {
       var queryString = queryElement.value.trim();
@@ -32,13 +50,123 @@ if (submitElement && queryElement) {
         resultsElement.innerHTML = "";
         return;
       }
-      if (cache[queryString]) {
-        resultsElement.innerHTML = cache[queryString];
+      var message = searchMessage(queryString);
+      if (cache[message]) {
+        resultsElement.innerHTML = cache[message];
         return;
       }
-      conn.send(queryString);
+      conn.send(message);
     }, autoQueryDelay);
 
     submitElement.updateState();
+  };
+
+  queryElement.addEventListener("input", search, false);
+  ["sort", "host", "std"].forEach(function(id) {
+    var optionElement = document.getElementById(id);
+    if (optionElement) {
+      optionElement.addEventListener("change", search, false);
+    }
+  });
+}
+
+// Completions
+
+var completionsElement = document.getElementById("completions");
+var completionKinds = {
+  p: "package", b: "command", t: "type", f: "func", m: "method",
+  c: "const", v: "var"
+};
+var completionRequest;
+var activeCompletion = -1;
+
+if (completionsElement && queryElement) {
+  completionsElement.close = function() {
+    completionsElement.className = "completions";
+    completionsElement.innerHTML = "";
+    activeCompletion = -1;
+  };
+
+  completionsElement.show = function(completions) {
+    completionsElement.close();
+    completions.forEach(function(c) {
+      var item = document.createElement("li");
+      var link = document.createElement("a");
+      var kind = document.createElement("span");
+      var name = document.createElement("strong");
+      var importPath = document.createElement("span");
+      link.href = c.link;
+      kind.className = "kind";
+      kind.textContent = completionKinds[c.kind] || c.kind;
+      name.textContent = c.recv ? c.recv + "." + c.name : c.name;
+      importPath.className = "import";
+      importPath.textContent = c.import;
+      link.appendChild(kind);
+      link.appendChild(name);
+      link.appendChild(importPath);
+      item.appendChild(link);
+      completionsElement.appendChild(item);
+    });
+    if (completions.length > 0) {
+      completionsElement.className = "completions open";
+    }
+  };
+
+  completionsElement.select = function(i) {
+    var items = completionsElement.children;
+    if (items.length <= 0) {
+      return;
+    }
+    activeCompletion = (i + items.length) % items.length;
+    for (var j = 0; j < items.length; j++) {
+      items[j].className = j === activeCompletion ? "active" : "";
+    }
+  };
+
+  queryElement.addEventListener("input", function(e) {
+    var prefix = queryElement.value.trim();
+    if (completionRequest) {
+      completionRequest.abort();
+    }
+    // Only single words are completed, queries are searched
+    if (prefix.length < 2 || /\s|:/.test(prefix)) {
+      completionsElement.close();
+      return;
+    }
+    completionRequest = new XMLHttpRequest();
+    completionRequest.open("GET", "/api/v1/complete?q=" + encodeURIComponent(prefix));
+    completionRequest.onload = function() {
+      if (this.status === 200) {
+        completionsElement.show(JSON.parse(this.responseText));
+      }
+    };
+    completionRequest.send();
+  }, false);
+
+  queryElement.addEventListener("keydown", function(e) {
+    switch (e.keyCode) {
+    case 40: // Down
+      completionsElement.select(activeCompletion + 1);
+      e.preventDefault();
+      break;
+    case 38: // Up
+      completionsElement.select(activeCompletion - 1);
+      e.preventDefault();
+      break;
+    case 13: // Enter
+      if (activeCompletion >= 0) {
+        window.location = completionsElement.children[activeCompletion].firstChild.href;
+        e.preventDefault();
+      }
+      break;
+    case 27: // Escape
+      completionsElement.close();
+      break;
+    }
+  }, false);
+
+  queryElement.addEventListener("blur", function(e) {
+    // Let clicks on a completion land first
+    setTimeout(completionsElement.close, 200);
   }, false);
 }